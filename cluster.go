@@ -0,0 +1,455 @@
+// cluster.go - manage the radosgw services built from the flags and configuration file
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const clusterLabel = "cluster"
+
+var (
+	errUnknownModule    = errors.New("unknown auth module")
+	errTargetNotAllowed = errors.New("target is not allowed by the auth module")
+)
+
+// clusterJob runs in background against a radosgw service until it is stopped, and
+// exports the metrics about itself.
+type clusterJob interface {
+	prometheus.Collector
+	Run(stop <-chan struct{})
+}
+
+// clusterTarget is a radosgw service collected by the metrics path, its metrics are
+// labeled by the cluster name unless it is given by the command line flags.
+type clusterTarget struct {
+	name      string
+	collector *RadosgwCollector
+	jobs      []clusterJob
+	registry  *prometheus.Registry
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newClusterTarget(name string, collector *RadosgwCollector, jobs ...clusterJob) (*clusterTarget, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if err := registry.Register(job); err != nil {
+			return nil, err
+		}
+	}
+	return &clusterTarget{
+		name:      name,
+		collector: collector,
+		jobs:      jobs,
+		registry:  registry,
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// start - start polling and the background jobs
+func (t *clusterTarget) start() {
+	if t.collector.pollInterval > 0 {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.collector.Poll(t.stop)
+		}()
+	}
+	for _, job := range t.jobs {
+		t.wg.Add(1)
+		go func(job clusterJob) {
+			defer t.wg.Done()
+			job.Run(t.stop)
+		}(job)
+	}
+}
+
+// shutdown - stop polling and the background jobs, and wait for their in-flight work
+func (t *clusterTarget) shutdown() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+func (t *clusterTarget) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := t.registry.Gather()
+	if len(t.name) == 0 {
+		return mfs, err
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String(clusterLabel),
+				Value: proto.String(t.name),
+			})
+			sort.Sort(prometheus.LabelPairSorter(m.Label))
+		}
+	}
+	return mfs, err
+}
+
+// clusterManager owns the collectors built from the command line flags and the
+// configuration file, and rebuilds all of them when the configuration is reloaded.
+type clusterManager struct {
+	filename string
+
+	// endpoint, credentials and pollInterval are given by the command line flags, the
+	// credentials are nil if the radosgw service is only given by the config file.
+	endpoint     string
+	credentials  radosgw.CredentialsProvider
+	pollInterval time.Duration
+
+	logger *slog.Logger
+
+	// lastReloadSuccessful shows whether the last reload succeeded.
+	lastReloadSuccessful prometheus.Gauge
+
+	// lastReloadSuccessTime shows when the last successful reload happened.
+	lastReloadSuccessTime prometheus.Gauge
+
+	reloadMtx sync.Mutex
+
+	mtx     sync.RWMutex
+	config  *Config
+	filters *labelFilters
+	targets []*clusterTarget
+	probes  *probeCache
+}
+
+func newClusterManager(filename, endpoint string, credentials radosgw.CredentialsProvider,
+	pollInterval time.Duration, logger *slog.Logger) *clusterManager {
+	m := &clusterManager{
+		filename:     filename,
+		endpoint:     endpoint,
+		credentials:  credentials,
+		pollInterval: pollInterval,
+		logger:       logger,
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "whether the last configuration reload attempt was successful",
+		}),
+		lastReloadSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "unix timestamp of the last successful configuration reload",
+		}),
+	}
+	prometheus.MustRegister(m.lastReloadSuccessful, m.lastReloadSuccessTime)
+	return m
+}
+
+// Reload - load the configuration file and rebuild all collectors, the current ones
+// are kept if any error occurs.
+func (m *clusterManager) Reload() error {
+	m.reloadMtx.Lock()
+	defer m.reloadMtx.Unlock()
+	err := m.reload()
+	if err != nil {
+		m.lastReloadSuccessful.Set(0)
+		m.logger.Error("load the config file failed", "file", m.filename, "err", err)
+		return err
+	}
+	if len(m.filename) != 0 {
+		m.logger.Info("load the config file succeeded", "file", m.filename)
+	}
+	m.lastReloadSuccessful.Set(1)
+	m.lastReloadSuccessTime.SetToCurrentTime()
+	return nil
+}
+
+func (m *clusterManager) reload() error {
+	config := &Config{}
+	if len(m.filename) != 0 {
+		var err error
+		if config, err = LoadConfig(m.filename); err != nil {
+			return err
+		}
+	}
+	filters, err := newLabelFilters(&config.Filters)
+	if err != nil {
+		return err
+	}
+	targets, err := m.buildTargets(config, filters)
+	if err != nil {
+		return err
+	}
+
+	// Stop the old targets before starting the new ones, so the background jobs never run
+	// twice, and the new targets serve the last snapshots until they are polled
+	m.mtx.RLock()
+	oldTargets := m.targets
+	m.mtx.RUnlock()
+	for _, t := range oldTargets {
+		t.shutdown()
+	}
+	for _, t := range targets {
+		for _, old := range oldTargets {
+			if old.name == t.name {
+				t.collector.inherit(old.collector)
+			}
+		}
+		t.start()
+	}
+
+	m.mtx.Lock()
+	m.config, m.filters, m.targets = config, filters, targets
+	m.probes = newProbeCache(*probeCacheSize, *probeCacheTTL)
+	m.mtx.Unlock()
+	return nil
+}
+
+// Stop - stop polling the radosgw services in background
+func (m *clusterManager) Stop() {
+	m.reloadMtx.Lock()
+	defer m.reloadMtx.Unlock()
+	m.mtx.Lock()
+	targets := m.targets
+	m.targets = nil
+	m.mtx.Unlock()
+	for _, t := range targets {
+		t.shutdown()
+	}
+}
+
+// Ready - check whether the last collection of every cluster succeeded
+func (m *clusterManager) Ready() bool {
+	m.mtx.RLock()
+	targets := m.targets
+	m.mtx.RUnlock()
+	for _, t := range targets {
+		if !t.collector.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *clusterManager) buildTargets(config *Config, filters *labelFilters) ([]*clusterTarget, error) {
+	targets := make([]*clusterTarget, 0, len(config.Clusters)+1)
+	if m.credentials != nil {
+		if len(config.Clusters) != 0 {
+			return nil, fmt.Errorf("clusters in config file conflict with the credentials flags")
+		}
+		client, err := radosgw.NewClientWithProvider(m.endpoint, m.credentials)
+		if err != nil {
+			return nil, err
+		}
+		opts := newCollectorOptions(config, filters, &config.Limits)
+		jobs, err := m.buildJobs(client, "", opts, config.UsageTrim, config.InventoryEvents,
+			config.QuotaNotifications, config.Policies, m.logger)
+		if err != nil {
+			return nil, err
+		}
+		collectors := newCollectors(opts, config.Collectors)
+		if err := checkNotifier(opts, collectors); err != nil {
+			return nil, err
+		}
+		if err := checkCost(config.UsageTrim, collectors); err != nil {
+			return nil, err
+		}
+		target, err := newClusterTarget("",
+			NewRadosgwCollector(client, m.pollInterval, collectors, m.logger), jobs...)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	for i := range config.Clusters {
+		cluster := &config.Clusters[i]
+		provider, err := cluster.Credentials.provider()
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		client, err := radosgw.NewClientWithProvider(cluster.Endpoint, provider)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		clusterFilters, limits := filters, &config.Limits
+		if cluster.Filters != nil {
+			if clusterFilters, err = newLabelFilters(cluster.Filters); err != nil {
+				return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		if cluster.Limits != nil {
+			limits = cluster.Limits
+		}
+		opts := newCollectorOptions(config, clusterFilters, limits)
+		pollInterval := m.pollInterval
+		if cluster.PollInterval != 0 {
+			pollInterval = cluster.PollInterval
+		}
+		logger := m.logger.With(clusterLabel, cluster.Name)
+		usageTrim, events := config.UsageTrim, config.InventoryEvents
+		if cluster.UsageTrim != nil {
+			usageTrim = cluster.UsageTrim
+		}
+		if cluster.InventoryEvents != nil {
+			events = cluster.InventoryEvents
+		}
+		notifications := config.QuotaNotifications
+		if cluster.QuotaNotifications != nil {
+			notifications = cluster.QuotaNotifications
+		}
+		policies := config.Policies
+		if cluster.Policies != nil {
+			policies = cluster.Policies
+		}
+		jobs, err := m.buildJobs(client, cluster.Name, opts, usageTrim, events, notifications,
+			policies, logger)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		collectors := newCollectors(opts, config.Collectors, cluster.Collectors)
+		if err := checkNotifier(opts, collectors); err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		if err := checkCost(usageTrim, collectors); err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		target, err := newClusterTarget(cluster.Name,
+			NewRadosgwCollector(client, pollInterval, collectors, logger), jobs...)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// buildJobs - create the background jobs which are configured, the usage checkpoints
+// should be persisted for the usage log trimmer so that the trimmed entries are never
+// lost from the totals, and the quota notifier is also given to the collectors by the
+// options
+func (m *clusterManager) buildJobs(client *radosgw.Client, cluster string, opts *collectorOptions,
+	usageTrim *UsageTrimConfig, events *InventoryEventsConfig, notifications *QuotaNotificationsConfig,
+	policies *PoliciesConfig, logger *slog.Logger) ([]clusterJob, error) {
+	jobs := make([]clusterJob, 0)
+	if usageTrim != nil {
+		if len(*usageStateDir) == 0 {
+			return nil, fmt.Errorf("usage_trim requires the -collector.usage.state.dir flag")
+		}
+		jobs = append(jobs, newUsageTrimmer(client, usageTrim, logger))
+	}
+	if events != nil {
+		jobs = append(jobs, newInventoryWatcher(client, cluster, events, opts.filters, logger))
+	}
+	if notifications != nil {
+		notifier, err := newQuotaNotifier(client.Endpoint(), cluster, notifications, logger)
+		if err != nil {
+			return nil, err
+		}
+		opts.notifier = notifier
+		jobs = append(jobs, notifier)
+	}
+	if policies != nil {
+		for i := range policies.Rules {
+			if policies.Rules[i].NewUsersOnly && len(*policyStateDir) == 0 {
+				return nil, fmt.Errorf("policies: new_users_only requires the -policies.state.dir flag")
+			}
+		}
+		engine, err := newPolicyEngine(client, cluster, policies, logger)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, engine)
+	}
+	return jobs, nil
+}
+
+// checkNotifier - check the quota collector is enabled to evaluate the quota notifications
+func checkNotifier(opts *collectorOptions, collectors []*namedCollector) error {
+	if opts.notifier == nil {
+		return nil
+	}
+	for _, c := range collectors {
+		if c.name == "quota" {
+			return nil
+		}
+	}
+	return fmt.Errorf("quota_notifications requires the quota collector")
+}
+
+// Gather - gather the metrics of all clusters
+func (m *clusterManager) Gather() ([]*dto.MetricFamily, error) {
+	m.mtx.RLock()
+	gatherers := make(prometheus.Gatherers, 0, len(m.targets))
+	for _, t := range m.targets {
+		gatherers = append(gatherers, t)
+	}
+	m.mtx.RUnlock()
+	return gatherers.Gather()
+}
+
+// reportTarget - get the client of the cluster, the configuration, the usage trimming of
+// the cluster and whether its bucket sizes are sampled by the growth collector for
+// /report, the cluster name may be empty if there is only one
+func (m *clusterManager) reportTarget(cluster string) (*radosgw.Client, *Config, *UsageTrimConfig, bool, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	for _, t := range m.targets {
+		if t.name == cluster || (len(cluster) == 0 && len(m.targets) == 1) {
+			usageTrim := m.config.UsageTrim
+			for i := range m.config.Clusters {
+				if m.config.Clusters[i].Name == t.name && m.config.Clusters[i].UsageTrim != nil {
+					usageTrim = m.config.Clusters[i].UsageTrim
+				}
+			}
+			sampled := false
+			for _, c := range t.collector.collectors {
+				sampled = sampled || c.name == "growth"
+			}
+			return t.collector.client, m.config, usageTrim, sampled, nil
+		}
+	}
+	return nil, nil, nil, false, errUnknownCluster
+}
+
+// probeCollector - get the cached collector of the target and auth module for /probe,
+// the target should be allowed by the auth module. The cache is dropped when the
+// configuration is reloaded, and the states of the collectors are never persisted.
+func (m *clusterManager) probeCollector(moduleName, target string) (*RadosgwCollector, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	module, ok := m.config.AuthModules[moduleName]
+	if !ok {
+		return nil, errUnknownModule
+	}
+	if !module.allowTarget(target) {
+		return nil, errTargetNotAllowed
+	}
+	key, now := moduleName+"@"+target, time.Now()
+	if collector := m.probes.get(key, now); collector != nil {
+		return collector, nil
+	}
+	provider, err := module.provider()
+	if err != nil {
+		return nil, err
+	}
+	client, err := radosgw.NewClientWithProvider(target, provider)
+	if err != nil {
+		return nil, err
+	}
+	opts := newCollectorOptions(m.config, m.filters, &m.config.Limits)
+	opts.ephemeral = true
+	collectors := newCollectors(opts, m.config.Collectors)
+	collector := NewRadosgwCollector(client, 0, collectors, m.logger.With("module", moduleName))
+	m.probes.add(key, collector, now)
+	return collector, nil
+}
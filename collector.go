@@ -3,181 +3,457 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/oshynsong/radosgw_exporter/radosgw"
 )
 
-const radosgwNamespace = "radosgw"
+const (
+	radosgwNamespace = "radosgw"
 
-type RadosgwCollector struct {
-	client *radosgw.Client
-
-	// BytesSent shows the total send throughput.
-	bytesSent []prometheus.Gauge
+	defaultCollectorTimeout = 30 * time.Second
+)
 
-	// BytesRecv shows the total received throughput.
-	bytesRecv []prometheus.Gauge
+var (
+	// snapshotAgeDesc shows how old the cached snapshot is in polling mode.
+	snapshotAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "exporter", "snapshot_age_seconds"),
+		"seconds since the cached snapshot of radosgw metrics was taken",
+		nil, nil)
 
-	// Ops shows the total operation called times.
-	ops []prometheus.Gauge
+	// upDesc shows whether the last collection from the radosgw service succeeded.
+	upDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "up"),
+		"whether the last collection from the radosgw service succeeded",
+		nil, nil)
 
-	// OpsOK shows the total operation called times successfully.
-	opsOK []prometheus.Gauge
+	// scrapeDurationDesc shows the time spent by each collector in the last collection.
+	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "exporter", "scrape_duration_seconds"),
+		"duration of the last collection by each collector",
+		[]string{"collector"}, nil)
 
-	// numObjects shows the total object number.
-	numObjects []prometheus.Gauge
+	// lastScrapeSuccessDesc shows when all collectors succeeded for the last time.
+	lastScrapeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "exporter", "last_scrape_success_timestamp"),
+		"unix timestamp of the last collection in which all collectors succeeded",
+		nil, nil)
+)
 
-	// capacity shows the current disk space occupied by all objects.
-	capacity []prometheus.Gauge
+// scrapeError is returned by a collector when it fails to get data from the radosgw
+// service, the reason is used to label the error counter.
+type scrapeError struct {
+	reason string
+	err    error
 }
 
-func NewRadosgwCollector(endpoint, ak, sk string) (*RadosgwCollector, error) {
-	cli, err := radosgw.NewClient(endpoint, ak, sk)
-	if err != nil {
-		return nil, err
+func newScrapeError(status int, err error) *scrapeError {
+	reason := "unexpected_status"
+	var credErr *radosgw.CredentialsError
+	switch {
+	case errors.As(err, &credErr):
+		// The credentials failed locally, which is not an error of the radosgw service
+		reason = "credentials"
+	case status >= 500:
+		reason = "server_error"
+	case status >= 400:
+		reason = "client_error"
+	case err != nil:
+		reason = "invalid_response"
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected status code %d", status)
 	}
-	return &RadosgwCollector{client: cli}, nil
+	return &scrapeError{reason: reason, err: err}
 }
 
-func (r *RadosgwCollector) Describe(ch chan<- *prometheus.Desc) {
-	r.collecting()
-	gauges := r.allGauges()
-	for i := range gauges {
-		ch <- gauges[i].Desc()
+func (e *scrapeError) Error() string { return e.err.Error() }
+
+// isNoSuchUser - check whether the user is not found by the status, such as deleted
+// after listing the users
+func isNoSuchUser(status int) bool { return status == http.StatusNotFound }
+
+// subCollector collects one group of metrics from the radosgw service.
+type subCollector interface {
+	// Describe sends the descriptors of all metrics the collector may produce.
+	Describe(ch chan<- *prometheus.Desc)
+
+	// Update fetches the data by the client and returns the produced metrics.
+	Update(client *radosgw.Client) ([]prometheus.Metric, error)
+}
+
+// collectorOptions holds the settings shared by the sub-collectors of a radosgw service.
+type collectorOptions struct {
+	filters     *labelFilters
+	aggregation aggregation
+
+	// maxSeries fails the sub-collector producing more series than it if it is positive.
+	maxSeries int
+
+	// topBuckets limits the exported buckets to the largest ones by topBucketsBy if it
+	// is positive.
+	topBuckets   int
+	topBucketsBy string
+
+	// families maps the usage categories to the operation families.
+	families opFamilies
+
+	// pricing is the price book of the cost estimation.
+	pricing *PricingConfig
+
+	// notifier evaluates the quota utilization, it is nil if not configured.
+	notifier *quotaNotifier
+
+	// ephemeral keeps the usage and growth states in the sub-collectors only, instead of
+	// sharing them by the endpoint and persisting them, which is used by the probes.
+	ephemeral bool
+}
+
+func newCollectorOptions(config *Config, filters *labelFilters, limits *LimitsConfig) *collectorOptions {
+	return &collectorOptions{
+		filters:     filters,
+		aggregation: aggregation(limits.Aggregation),
+		maxSeries:   limits.MaxSeries,
+
+		topBuckets:   limits.TopBuckets,
+		topBucketsBy: limits.TopBucketsBy,
+		families:     newOpFamilies(config.OpFamilies),
+		pricing:      &config.Pricing,
 	}
 }
 
-func (r *RadosgwCollector) Collect(ch chan<- prometheus.Metric) {
-	r.collecting()
-	gauges := r.allGauges()
-	for i := range gauges {
-		ch <- gauges[i]
+// collectorEntry is a registered sub-collector with its command line flags.
+type collectorEntry struct {
+	name     string
+	factory  func(opts *collectorOptions) subCollector
+	enabled  *bool
+	disabled *bool
+	timeout  *time.Duration
+}
+
+// collectorEntries keeps all registered sub-collectors in the registration order.
+var collectorEntries []*collectorEntry
+
+// registerCollector - register a sub-collector and define the flags to enable or disable
+// it and to set its timeout, it must be called before parsing the command line.
+func registerCollector(name string, enabledByDefault bool,
+	factory func(opts *collectorOptions) subCollector) {
+	collectorEntries = append(collectorEntries, &collectorEntry{
+		name:    name,
+		factory: factory,
+		enabled: flag.Bool("collector."+name, enabledByDefault,
+			fmt.Sprintf("enable the %s collector", name)),
+		disabled: flag.Bool("no-collector."+name, false,
+			fmt.Sprintf("disable the %s collector", name)),
+		timeout: flag.Duration("collector."+name+".timeout", defaultCollectorTimeout,
+			fmt.Sprintf("timeout of the %s collector, 0 means no timeout", name)),
+	})
+}
+
+// namedCollector is an enabled sub-collector instance.
+type namedCollector struct {
+	name      string
+	timeout   time.Duration
+	maxSeries int
+	collector subCollector
+
+	// call is the running update which the concurrent scrapes wait for instead of
+	// starting another one, it is nil if the sub-collector is idle.
+	mtx  sync.Mutex
+	call *collectorCall
+}
+
+// collectorCall is a running update of a sub-collector, done is closed once it returns.
+type collectorCall struct {
+	done    chan struct{}
+	metrics []prometheus.Metric
+	err     error
+}
+
+func findCollectorEntry(name string) *collectorEntry {
+	for _, e := range collectorEntries {
+		if e.name == name {
+			return e
+		}
 	}
+	return nil
 }
 
-func (r *RadosgwCollector) allGauges() []prometheus.Gauge {
-	result := make([]prometheus.Gauge, 0)
-	result = append(result, r.bytesSent...)
-	result = append(result, r.bytesRecv...)
-	result = append(result, r.ops...)
-	result = append(result, r.opsOK...)
-	result = append(result, r.numObjects...)
-	result = append(result, r.capacity...)
+// newCollectors - create the enabled sub-collectors, the state and timeout given by the
+// command line flags are overridden by the settings in order.
+func newCollectors(opts *collectorOptions, settings ...map[string]CollectorConfig) []*namedCollector {
+	result := make([]*namedCollector, 0, len(collectorEntries))
+	for _, e := range collectorEntries {
+		enabled, timeout := *e.enabled && !*e.disabled, *e.timeout
+		for _, s := range settings {
+			if c, ok := s[e.name]; ok {
+				if c.Enabled != nil {
+					enabled = *c.Enabled
+				}
+				if c.Timeout != 0 {
+					timeout = c.Timeout
+				}
+			}
+		}
+		if enabled {
+			result = append(result, &namedCollector{
+				name:      e.name,
+				timeout:   timeout,
+				maxSeries: opts.maxSeries,
+				collector: e.factory(opts),
+			})
+		}
+	}
 	return result
 }
 
-func (r *RadosgwCollector) collecting() {
-	// Collect the bucket usage data
-	status, bucketStats, err := r.client.GetBucket("", "", true)
-	if err != nil || status > 200 {
-		fmt.Printf("collect the radosgw bucket stats failed: %v", err)
-		return
+// update - run the sub-collector and drop its result if it has too many series
+func (c *namedCollector) update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	metrics, err := c.run(client)
+	if err == nil && c.maxSeries > 0 && len(metrics) > c.maxSeries {
+		return nil, &scrapeError{"series_limit",
+			fmt.Errorf("%d series exceed the limit %d", len(metrics), c.maxSeries)}
 	}
-	r.numObjects = make([]prometheus.Gauge, 0)
-	r.capacity = make([]prometheus.Gauge, 0)
-	for i := range bucketStats {
-		stats := bucketStats[i].Stats
-		numObjectsGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+	return metrics, err
+}
+
+// run - run the sub-collector and give up waiting for it after the timeout, an update
+// still running after a timeout is joined by the next scrapes instead of piling up more
+// requests on a slow radosgw service
+func (c *namedCollector) run(client *radosgw.Client) ([]prometheus.Metric, error) {
+	c.mtx.Lock()
+	call := c.call
+	if call == nil {
+		call = &collectorCall{done: make(chan struct{})}
+		c.call = call
+		go func() {
+			call.metrics, call.err = c.collector.Update(client)
+			c.mtx.Lock()
+			c.call = nil
+			c.mtx.Unlock()
+			close(call.done)
+		}()
+	}
+	c.mtx.Unlock()
+
+	if c.timeout <= 0 {
+		<-call.done
+		return call.metrics, call.err
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		return call.metrics, call.err
+	case <-timer.C:
+		return nil, &scrapeError{"timeout", fmt.Errorf("no response in %v", c.timeout)}
+	}
+}
+
+// radosgwSnapshot holds the metrics produced by one round of collection. It is never
+// modified after creation, so it can be shared between concurrent scrapes.
+type radosgwSnapshot struct {
+	metrics   []prometheus.Metric
+	timestamp time.Time
+}
+
+type RadosgwCollector struct {
+	client     *radosgw.Client
+	collectors []*namedCollector
+
+	// pollInterval enables the background polling mode if it is positive.
+	pollInterval time.Duration
+
+	// scrapeErrors counts the failed collections by collector and reason.
+	scrapeErrors *prometheus.CounterVec
+
+	// requestDuration observes the latency of the admin OP API requests.
+	requestDuration *prometheus.HistogramVec
+
+	logger *slog.Logger
+
+	mtx         sync.RWMutex
+	snapshot    *radosgwSnapshot
+	lastSuccess time.Time
+	lastUp      *bool
+
+	// warming shows the first collection is started by the readiness check.
+	warming bool
+}
+
+func NewRadosgwCollector(cli *radosgw.Client, pollInterval time.Duration,
+	collectors []*namedCollector, logger *slog.Logger) *RadosgwCollector {
+	logger = logger.With("endpoint", cli.Endpoint())
+	r := &RadosgwCollector{
+		client:       cli,
+		collectors:   collectors,
+		pollInterval: pollInterval,
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: radosgwNamespace,
-			Name:      "num_objects",
-			Help:      "total object number",
-			ConstLabels: prometheus.Labels{
-				"user":   stats.Owner,
-				"bucket": stats.Bucket,
-			},
-		})
-		numObjectsGauge.Set(float64(stats.Usage.RgwMain.NumObjects))
-		r.numObjects = append(r.numObjects, numObjectsGauge)
-
-		capacityGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: "exporter",
+			Name:      "scrape_errors_total",
+			Help:      "total number of failed collections by collector and reason",
+		}, []string{"collector", "reason"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Namespace: radosgwNamespace,
-			Name:      "capacity",
-			Help:      "current disk space usage of all objects",
-			ConstLabels: prometheus.Labels{
-				"user":   stats.Owner,
-				"bucket": stats.Bucket,
-			},
-		})
-		capacityGauge.Set(float64(stats.Usage.RgwMain.Size))
-		r.capacity = append(r.capacity, capacityGauge)
-	}
-
-	// Collect the API usage data by users
-	r.bytesSent = make([]prometheus.Gauge, 0)
-	r.bytesRecv = make([]prometheus.Gauge, 0)
-	r.ops = make([]prometheus.Gauge, 0)
-	r.opsOK = make([]prometheus.Gauge, 0)
-	status, usage, err := r.client.GetUsage("", nil, nil, false, false)
-	if err != nil || status > 200 {
-		fmt.Printf("collect the radosgw usage metrics failed: %v", err)
+			Subsystem: "exporter",
+			Name:      "request_duration_seconds",
+			Help:      "latency of the requests to the radosgw admin OP API",
+		}, []string{"method", "path"}),
+		logger: logger,
+	}
+	cli.SetLogger(logger)
+	cli.SetRequestObserver(func(method, uri string, status int, elapsed time.Duration) {
+		r.requestDuration.WithLabelValues(method, uri).Observe(elapsed.Seconds())
+	})
+	return r
+}
+
+func (r *RadosgwCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range r.collectors {
+		c.collector.Describe(ch)
+	}
+	ch <- upDesc
+	ch <- scrapeDurationDesc
+	ch <- lastScrapeSuccessDesc
+	if r.pollInterval > 0 {
+		ch <- snapshotAgeDesc
+	}
+	r.scrapeErrors.Describe(ch)
+	r.requestDuration.Describe(ch)
+}
+
+func (r *RadosgwCollector) Collect(ch chan<- prometheus.Metric) {
+	defer r.requestDuration.Collect(ch)
+	defer r.scrapeErrors.Collect(ch)
+	if r.pollInterval <= 0 {
+		for _, m := range r.collecting().metrics {
+			ch <- m
+		}
 		return
 	}
-	if len(usage.Entries) == 0 {
+
+	// Serve the metrics from the cached snapshot in polling mode
+	r.mtx.RLock()
+	snapshot := r.snapshot
+	r.mtx.RUnlock()
+	if snapshot == nil {
 		return
 	}
-	for i := range usage.Entries {
-		userLabel := usage.Entries[i].User
-		buckets := usage.Entries[i].Buckets
-		for k := range buckets {
-			bucketLabel := buckets[k].Bucket
-			categories := buckets[k].Categories
-			for c := range categories {
-				apiLabel := categories[c].Category
-				bytesSentGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-					Namespace: radosgwNamespace,
-					Name:      "bytes_sent_total",
-					Help:      "currently total sent throughput",
-					ConstLabels: prometheus.Labels{
-						"user":   userLabel,
-						"bucket": bucketLabel,
-						"api":    apiLabel,
-					},
-				})
-				bytesSentGauge.Set(float64(categories[c].BytesSent))
-				r.bytesSent = append(r.bytesSent, bytesSentGauge)
-
-				bytesRecvGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-					Namespace: radosgwNamespace,
-					Name:      "bytes_recv_total",
-					Help:      "currently total recv throughput",
-					ConstLabels: prometheus.Labels{
-						"user":   userLabel,
-						"bucket": bucketLabel,
-						"api":    apiLabel,
-					},
-				})
-				bytesRecvGauge.Set(float64(categories[c].BytesReceived))
-				r.bytesRecv = append(r.bytesRecv, bytesRecvGauge)
-
-				opsGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-					Namespace: radosgwNamespace,
-					Name:      "ops_total",
-					Help:      "currently total ops",
-					ConstLabels: prometheus.Labels{
-						"user":   userLabel,
-						"bucket": bucketLabel,
-						"api":    apiLabel,
-					},
-				})
-				opsGauge.Set(float64(categories[c].Ops))
-				r.ops = append(r.ops, opsGauge)
-
-				opsOKGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-					Namespace: radosgwNamespace,
-					Name:      "ops_ok_total",
-					Help:      "currently total ops ok",
-					ConstLabels: prometheus.Labels{
-						"user":   userLabel,
-						"bucket": bucketLabel,
-						"api":    apiLabel,
-					},
-				})
-				opsOKGauge.Set(float64(categories[c].SuccessfulOps))
-				r.opsOK = append(r.opsOK, opsOKGauge)
+	for _, m := range snapshot.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue,
+		time.Since(snapshot.timestamp).Seconds())
+}
+
+// Poll - refresh the cached snapshot every poll interval until the stop channel is closed.
+func (r *RadosgwCollector) Poll(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		snapshot := r.collecting()
+		r.mtx.Lock()
+		r.snapshot = snapshot
+		r.mtx.Unlock()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// inherit - take over the snapshot and the last state of the collector replaced by the
+// configuration reload, so the metrics are not absent until the first poll
+func (r *RadosgwCollector) inherit(old *RadosgwCollector) {
+	old.mtx.RLock()
+	snapshot, lastSuccess, lastUp := old.snapshot, old.lastSuccess, old.lastUp
+	old.mtx.RUnlock()
+	r.mtx.Lock()
+	r.snapshot, r.lastSuccess, r.lastUp = snapshot, lastSuccess, lastUp
+	r.mtx.Unlock()
+}
+
+// Ready - check whether the last collection succeeded, it is not ready until the first
+// collection completes, which is started in background once if not polling.
+func (r *RadosgwCollector) Ready() bool {
+	r.mtx.Lock()
+	lastUp := r.lastUp
+	start := lastUp == nil && !r.warming && r.pollInterval <= 0
+	if start {
+		r.warming = true
+	}
+	r.mtx.Unlock()
+	if start {
+		go r.collecting()
+	}
+	return lastUp != nil && *lastUp
+}
+
+func (r *RadosgwCollector) collecting() *radosgwSnapshot {
+	result := &radosgwSnapshot{timestamp: time.Now()}
+
+	// Run all sub-collectors concurrently and merge the results in order
+	metrics := make([][]prometheus.Metric, len(r.collectors))
+	durations := make([]time.Duration, len(r.collectors))
+	errs := make([]error, len(r.collectors))
+	wg := sync.WaitGroup{}
+	for i := range r.collectors {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			begin := time.Now()
+			metrics[i], errs[i] = r.collectors[i].update(r.client)
+			durations[i] = time.Since(begin)
+		}(i)
+	}
+	wg.Wait()
+
+	up := 1.0
+	for i, c := range r.collectors {
+		result.metrics = append(result.metrics, prometheus.MustNewConstMetric(
+			scrapeDurationDesc, prometheus.GaugeValue, durations[i].Seconds(), c.name))
+		if errs[i] != nil {
+			up = 0
+			reason := "unknown"
+			var credErr *radosgw.CredentialsError
+			if e, ok := errs[i].(*scrapeError); ok {
+				reason = e.reason
+			} else if errors.As(errs[i], &credErr) {
+				reason = "credentials"
 			}
+			r.scrapeErrors.WithLabelValues(c.name, reason).Inc()
+			r.logger.Error("collect the radosgw metrics failed", "collector", c.name,
+				"duration", durations[i], "reason", reason, "err", errs[i])
+			continue
 		}
+		r.logger.Debug("collect the radosgw metrics succeeded", "collector", c.name,
+			"duration", durations[i], "metrics", len(metrics[i]))
+		result.metrics = append(result.metrics, metrics[i]...)
+	}
+	result.metrics = append(result.metrics,
+		prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up))
+
+	r.mtx.Lock()
+	if up == 1 {
+		r.lastSuccess = result.timestamp
 	}
+	lastUp := up == 1
+	r.lastUp = &lastUp
+	lastSuccess := r.lastSuccess
+	r.mtx.Unlock()
+	if !lastSuccess.IsZero() {
+		result.metrics = append(result.metrics, prometheus.MustNewConstMetric(
+			lastScrapeSuccessDesc, prometheus.GaugeValue, float64(lastSuccess.Unix())))
+	}
+	return result
 }
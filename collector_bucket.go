@@ -0,0 +1,219 @@
+// collector_bucket.go - implement the collector of bucket stats
+
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+var (
+	// numObjectsDesc shows the total object number.
+	numObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "num_objects"),
+		"total object number",
+		[]string{"tenant", "user", "bucket"}, nil)
+
+	// capacityDesc shows the current disk space occupied by all objects.
+	capacityDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "capacity"),
+		"current disk space usage of all objects",
+		[]string{"tenant", "user", "bucket"}, nil)
+
+	// bucketSizeDesc shows the size of each usage category of the bucket.
+	bucketSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "size_bytes"),
+		"total size in bytes of the objects in the usage category of the bucket",
+		[]string{"tenant", "user", "bucket", "category"}, nil)
+
+	// bucketSizeActualDesc shows the size rounded to the allocation unit.
+	bucketSizeActualDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "size_actual_bytes"),
+		"total size in bytes rounded to the allocation unit of the objects in the usage category of the bucket",
+		[]string{"tenant", "user", "bucket", "category"}, nil)
+
+	// bucketObjectsDesc shows the object number of each usage category of the bucket.
+	bucketObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "objects"),
+		"object number in the usage category of the bucket",
+		[]string{"tenant", "user", "bucket", "category"}, nil)
+
+	// bucketInfoDesc shows the placement of the bucket.
+	bucketInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "info"),
+		"information of the bucket, always 1",
+		[]string{"tenant", "user", "bucket", "placement_rule", "zonegroup", "index_pool"}, nil)
+
+	// bucketCreationTimeDesc shows when the bucket was created.
+	bucketCreationTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "creation_timestamp_seconds"),
+		"unix timestamp of the bucket creation",
+		[]string{"tenant", "user", "bucket"}, nil)
+
+	// bucketModifiedTimeDesc shows when the bucket was modified for the last time.
+	bucketModifiedTimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "modified_timestamp_seconds"),
+		"unix timestamp of the last modification of the bucket metadata",
+		[]string{"tenant", "user", "bucket"}, nil)
+)
+
+const (
+	topBySize    = "size"
+	topByObjects = "objects"
+
+	// otherBucket is the bucket label of the sum of the buckets out of the top N.
+	otherBucket = "__other__"
+)
+
+func init() {
+	registerCollector("bucket", true, func(opts *collectorOptions) subCollector {
+		return &bucketCollector{
+			filters:      opts.filters,
+			aggregation:  opts.aggregation,
+			topBuckets:   opts.topBuckets,
+			topBucketsBy: opts.topBucketsBy,
+		}
+	})
+}
+
+// bucketCollector collects the usage of each category and the metadata of each bucket.
+type bucketCollector struct {
+	filters      *labelFilters
+	aggregation  aggregation
+	topBuckets   int
+	topBucketsBy string
+}
+
+func (b *bucketCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- numObjectsDesc
+	ch <- capacityDesc
+	ch <- bucketSizeDesc
+	ch <- bucketSizeActualDesc
+	ch <- bucketObjectsDesc
+	ch <- bucketInfoDesc
+	ch <- bucketCreationTimeDesc
+	ch <- bucketModifiedTimeDesc
+}
+
+func (b *bucketCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	status, bucketStats, err := client.GetBucket("", "", true)
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+
+	// Sum up the buckets sharing the labels kept by the aggregation, the bucket metrics
+	// have no category label so they are summed up to the total of the cluster by it.
+	type bucketKey struct{ tenant, user, bucket string }
+	totals := make(map[bucketKey]*bucketTotal)
+	keys := make([]bucketKey, 0, len(bucketStats))
+	for i := range bucketStats {
+		stats := bucketStats[i].Stats
+		if stats == nil {
+			continue
+		}
+		tenant, user, bucket := tenantLabels(stats.Owner, stats.Bucket)
+		if len(stats.Tenant) != 0 {
+			tenant = stats.Tenant
+		}
+		if !b.filters.matchBucket(stats.Owner, radosgw.JoinBucketName(tenant, bucket)) {
+			continue
+		}
+		key := bucketKey{}
+		key.tenant, key.user, key.bucket, _ = b.aggregation.labels(tenant, user, bucket, "")
+		total, ok := totals[key]
+		if !ok {
+			total = &bucketTotal{stats: stats}
+			totals[key] = total
+			keys = append(keys, key)
+		} else {
+			total.stats = nil
+		}
+		total.add(stats.Usage)
+	}
+
+	// Only keep the largest buckets and sum up the other ones, so the totals stay correct
+	if b.topBuckets > 0 && len(keys) > b.topBuckets {
+		sort.SliceStable(keys, func(i, j int) bool {
+			if b.topBucketsBy == topByObjects {
+				return totals[keys[i]].main().NumObjects > totals[keys[j]].main().NumObjects
+			}
+			return totals[keys[i]].main().Size > totals[keys[j]].main().Size
+		})
+		other := &bucketTotal{}
+		for _, key := range keys[b.topBuckets:] {
+			other.add(totals[key].usage)
+		}
+		otherKey := bucketKey{bucket: otherBucket}
+		keys = append(keys[:b.topBuckets], otherKey)
+		totals[otherKey] = other
+	}
+	result := make([]prometheus.Metric, 0, 2*len(keys))
+	for _, key := range keys {
+		total := totals[key]
+		result = append(result,
+			prometheus.MustNewConstMetric(numObjectsDesc, prometheus.GaugeValue,
+				float64(total.main().NumObjects), key.tenant, key.user, key.bucket),
+			prometheus.MustNewConstMetric(capacityDesc, prometheus.GaugeValue,
+				float64(total.main().Size), key.tenant, key.user, key.bucket))
+		categories := make([]string, 0, len(total.usage))
+		for category := range total.usage {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			usage := total.usage[category]
+			result = append(result,
+				prometheus.MustNewConstMetric(bucketSizeDesc, prometheus.GaugeValue,
+					float64(usage.Size), key.tenant, key.user, key.bucket, category),
+				prometheus.MustNewConstMetric(bucketSizeActualDesc, prometheus.GaugeValue,
+					float64(usage.SizeActual), key.tenant, key.user, key.bucket, category),
+				prometheus.MustNewConstMetric(bucketObjectsDesc, prometheus.GaugeValue,
+					float64(usage.NumObjects), key.tenant, key.user, key.bucket, category))
+		}
+
+		// The metadata is only exported for the series standing for exactly one bucket
+		if total.stats == nil {
+			continue
+		}
+		result = append(result, prometheus.MustNewConstMetric(bucketInfoDesc,
+			prometheus.GaugeValue, 1, key.tenant, key.user, key.bucket,
+			total.stats.PlacementRule, total.stats.Zonegroup, total.stats.IndexPool))
+		if t, err := radosgw.ParseTime(total.stats.CreationTime); err == nil {
+			result = append(result, prometheus.MustNewConstMetric(bucketCreationTimeDesc,
+				prometheus.GaugeValue, float64(t.Unix()), key.tenant, key.user, key.bucket))
+		}
+		if t, err := radosgw.ParseTime(total.stats.Mtime); err == nil {
+			result = append(result, prometheus.MustNewConstMetric(bucketModifiedTimeDesc,
+				prometheus.GaugeValue, float64(t.Unix()), key.tenant, key.user, key.bucket))
+		}
+	}
+	return result, nil
+}
+
+// bucketTotal is the sum of the usage of the buckets sharing the same labels, the stats
+// is only kept if there is exactly one bucket.
+type bucketTotal struct {
+	usage map[string]radosgw.BucketUsageCategoryType
+	stats *radosgw.BucketStatsType
+}
+
+func (t *bucketTotal) add(usage map[string]radosgw.BucketUsageCategoryType) {
+	if t.usage == nil {
+		t.usage = make(map[string]radosgw.BucketUsageCategoryType)
+	}
+	for category, u := range usage {
+		sum := t.usage[category]
+		sum.Size += u.Size
+		sum.SizeActual += u.SizeActual
+		sum.SizeUtilized += u.SizeUtilized
+		sum.NumObjects += u.NumObjects
+		t.usage[category] = sum
+	}
+}
+
+func (t *bucketTotal) main() radosgw.BucketUsageCategoryType {
+	return t.usage[radosgw.BucketUsageMain]
+}
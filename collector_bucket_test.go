@@ -0,0 +1,269 @@
+// collector_bucket_test.go - test the collector of bucket stats
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+// seriesOf - get the values of the metrics of the descriptor by their labels, such as
+// `bucket="b1",tenant="",user="alice"`
+func seriesOf(t *testing.T, metrics []prometheus.Metric, desc *prometheus.Desc) map[string]float64 {
+	t.Helper()
+	result := make(map[string]float64)
+	for _, m := range metrics {
+		if m.Desc() != desc {
+			continue
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("write the metric failed: %v", err)
+		}
+		labels := make([]string, 0, len(pb.Label))
+		for _, l := range pb.Label {
+			labels = append(labels, l.GetName()+`="`+l.GetValue()+`"`)
+		}
+		sort.Strings(labels)
+		key := strings.Join(labels, ",")
+		if _, ok := result[key]; ok {
+			t.Fatalf("got the duplicated series %s", key)
+		}
+		switch {
+		case pb.Gauge != nil:
+			result[key] = pb.Gauge.GetValue()
+		case pb.Counter != nil:
+			result[key] = pb.Counter.GetValue()
+		}
+	}
+	return result
+}
+
+func updateBuckets(t *testing.T, f *fakeRadosgw, collector *bucketCollector) []prometheus.Metric {
+	t.Helper()
+	metrics, err := collector.Update(f.client)
+	if err != nil {
+		t.Fatalf("update the buckets failed: %v", err)
+	}
+	return metrics
+}
+
+func testBucketCategories(owner, bucket string, main, multipart int64) *radosgw.BucketStatsType {
+	stats := testBucketStats(owner, bucket, main)
+	stats.Usage[radosgw.BucketUsageMain] = radosgw.BucketUsageCategoryType{Size: main, NumObjects: main / 10}
+	stats.Usage["rgw.multimeta"] = radosgw.BucketUsageCategoryType{Size: multipart, NumObjects: 1}
+	stats.PlacementRule = "default-placement"
+	return stats
+}
+
+func TestBucketCollectorAggregation(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.setBuckets(testBucketCategories("alice", "b1", 100, 1), testBucketCategories("alice", "b2", 200, 2),
+		testBucketCategories("bob", "b3", 400, 4))
+	cases := []struct {
+		name        string
+		aggregation aggregation
+		capacity    map[string]float64
+		sizes       map[string]float64
+		infos       int
+	}{
+		{
+			name:        "none",
+			aggregation: aggregateNone,
+			capacity: map[string]float64{
+				`bucket="b1",tenant="",user="alice"`: 100,
+				`bucket="b2",tenant="",user="alice"`: 200,
+				`bucket="b3",tenant="",user="bob"`:   400,
+			},
+			infos: 3,
+		},
+		{
+			name:        "user",
+			aggregation: aggregateUser,
+			capacity: map[string]float64{
+				`bucket="",tenant="",user="alice"`: 300,
+				`bucket="",tenant="",user="bob"`:   400,
+			},
+			sizes: map[string]float64{
+				`bucket="",category="rgw.main",tenant="",user="alice"`:      300,
+				`bucket="",category="rgw.multimeta",tenant="",user="alice"`: 3,
+				`bucket="",category="rgw.main",tenant="",user="bob"`:        400,
+				`bucket="",category="rgw.multimeta",tenant="",user="bob"`:   4,
+			},
+			// The metadata is only exported for the series of a single bucket
+			infos: 1,
+		},
+		{
+			name:        "category",
+			aggregation: aggregateCategory,
+			capacity:    map[string]float64{`bucket="",tenant="",user=""`: 700},
+			sizes: map[string]float64{
+				`bucket="",category="rgw.main",tenant="",user=""`:      700,
+				`bucket="",category="rgw.multimeta",tenant="",user=""`: 7,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics := updateBuckets(t, f, &bucketCollector{aggregation: c.aggregation})
+			if got := seriesOf(t, metrics, capacityDesc); !equalSeries(got, c.capacity) {
+				t.Errorf("got capacity %v, want %v", got, c.capacity)
+			}
+			if c.sizes != nil {
+				if got := seriesOf(t, metrics, bucketSizeDesc); !equalSeries(got, c.sizes) {
+					t.Errorf("got sizes %v, want %v", got, c.sizes)
+				}
+			}
+			if n := len(seriesOf(t, metrics, bucketInfoDesc)); n != c.infos {
+				t.Errorf("got %d bucket info series, want %d", n, c.infos)
+			}
+		})
+	}
+}
+
+func equalSeries(got, want map[string]float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			return false
+		}
+	}
+	return true
+}
+
+func sumSeries(series map[string]float64) float64 {
+	var sum float64
+	for _, v := range series {
+		sum += v
+	}
+	return sum
+}
+
+func TestBucketCollectorTopBuckets(t *testing.T) {
+	f := newFakeRadosgw(t)
+	// The smallest bucket has the most objects
+	b4 := testBucketCategories("bob", "b4", 50, 8)
+	b4.Usage[radosgw.BucketUsageMain] = radosgw.BucketUsageCategoryType{Size: 50, NumObjects: 1000}
+	f.setBuckets(testBucketCategories("alice", "b1", 100, 1), testBucketCategories("alice", "b2", 300, 2),
+		testBucketCategories("bob", "b3", 200, 4), b4)
+	all := updateBuckets(t, f, &bucketCollector{})
+	cases := []struct {
+		name       string
+		topBuckets int
+		by         string
+		capacity   map[string]float64
+	}{
+		{
+			name:       "by size",
+			topBuckets: 2,
+			by:         topBySize,
+			capacity: map[string]float64{
+				`bucket="b2",tenant="",user="alice"`:   300,
+				`bucket="b3",tenant="",user="bob"`:     200,
+				`bucket="__other__",tenant="",user=""`: 150,
+			},
+		},
+		{
+			name:       "by objects",
+			topBuckets: 1,
+			by:         topByObjects,
+			capacity: map[string]float64{
+				`bucket="b4",tenant="",user="bob"`:     50,
+				`bucket="__other__",tenant="",user=""`: 600,
+			},
+		},
+		{
+			name:       "within the top",
+			topBuckets: 4,
+			by:         topBySize,
+			capacity: map[string]float64{
+				`bucket="b1",tenant="",user="alice"`: 100,
+				`bucket="b2",tenant="",user="alice"`: 300,
+				`bucket="b3",tenant="",user="bob"`:   200,
+				`bucket="b4",tenant="",user="bob"`:   50,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			metrics := updateBuckets(t, f, &bucketCollector{topBuckets: c.topBuckets, topBucketsBy: c.by})
+			if got := seriesOf(t, metrics, capacityDesc); !equalSeries(got, c.capacity) {
+				t.Errorf("got capacity %v, want %v", got, c.capacity)
+			}
+
+			// The totals of all metrics are kept by the roll-up
+			for _, desc := range []*prometheus.Desc{capacityDesc, numObjectsDesc, bucketSizeDesc,
+				bucketSizeActualDesc, bucketObjectsDesc} {
+				if got, want := sumSeries(seriesOf(t, metrics, desc)), sumSeries(seriesOf(t, all, desc)); got != want {
+					t.Errorf("got the total %v of %s, want %v", got, desc, want)
+				}
+			}
+			for key := range seriesOf(t, metrics, bucketInfoDesc) {
+				if strings.Contains(key, otherBucket) {
+					t.Errorf("got the bucket info of the rolled up buckets")
+				}
+			}
+		})
+	}
+}
+
+func TestBucketCollectorTenants(t *testing.T) {
+	f := newFakeRadosgw(t)
+	tenanted := testBucketCategories("acme$alice", "b1", 100, 1)
+	tenanted.Tenant = "acme"
+	f.setBuckets(tenanted, testBucketCategories("alice", "b1", 200, 2))
+	cases := []struct {
+		name     string
+		filters  FiltersConfig
+		capacity map[string]float64
+	}{
+		{
+			name: "all",
+			capacity: map[string]float64{
+				`bucket="b1",tenant="acme",user="alice"`: 100,
+				`bucket="b1",tenant="",user="alice"`:     200,
+			},
+		},
+		{
+			// The filters match the qualified user ids and bucket names
+			name:     "tenanted user",
+			filters:  FiltersConfig{Users: FilterConfig{Include: `acme\$.*`}},
+			capacity: map[string]float64{`bucket="b1",tenant="acme",user="alice"`: 100},
+		},
+		{
+			name:     "tenanted bucket",
+			filters:  FiltersConfig{Buckets: FilterConfig{Exclude: "acme/.*"}},
+			capacity: map[string]float64{`bucket="b1",tenant="",user="alice"`: 200},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filters, err := newLabelFilters(&c.filters)
+			if err != nil {
+				t.Fatalf("create the filters failed: %v", err)
+			}
+			metrics := updateBuckets(t, f, &bucketCollector{filters: filters})
+			if got := seriesOf(t, metrics, capacityDesc); !equalSeries(got, c.capacity) {
+				t.Errorf("got capacity %v, want %v", got, c.capacity)
+			}
+		})
+	}
+
+	// The user aggregation keeps the users of different tenants apart
+	metrics := updateBuckets(t, f, &bucketCollector{aggregation: aggregateUser})
+	want := map[string]float64{
+		`bucket="",tenant="acme",user="alice"`: 100,
+		`bucket="",tenant="",user="alice"`:     200,
+	}
+	if got := seriesOf(t, metrics, capacityDesc); !equalSeries(got, want) {
+		t.Errorf("got capacity %v by user, want %v", got, want)
+	}
+}
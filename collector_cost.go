@@ -0,0 +1,128 @@
+// collector_cost.go - implement the collector of the estimated cost
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const (
+	costComponentStorage  = "storage"
+	costComponentRequests = "requests"
+	costComponentEgress   = "egress"
+)
+
+// costMinRetention is the min retention of the usage trimming with the cost collector, so
+// the usage log entries of the current month are never trimmed.
+const costMinRetention = 31 * 24 * time.Hour
+
+var (
+	costInterval = flag.Duration("collector.cost.interval", 15*time.Minute,
+		"min interval between the estimations of the cost from the usage log of the month")
+
+	// estimatedCostDesc shows the estimated cost of each user in the current month, the
+	// prices of the pricing are regarded as dollars.
+	estimatedCostDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "estimated_cost_dollars"),
+		"estimated cost in dollars of the user in the current month by the pricing of the config file",
+		[]string{"tenant", "user", "component"}, nil)
+)
+
+func init() {
+	registerCollector("cost", false, func(opts *collectorOptions) subCollector {
+		return &costCollector{
+			filters:     opts.filters,
+			aggregation: opts.aggregation,
+			families:    opts.families,
+			pricing:     opts.pricing,
+			interval:    *costInterval,
+		}
+	})
+}
+
+// costCollector collects the running cost of each user since the start of the month in
+// the same way as the chargeback reports. It fetches the usage log entries of the whole
+// month, so it is disabled by default, and the report is only generated again once the
+// interval passes.
+type costCollector struct {
+	filters     *labelFilters
+	aggregation aggregation
+	families    opFamilies
+	pricing     *PricingConfig
+	interval    time.Duration
+
+	// report is the last generated report of the month, at the time generated.
+	report    *chargebackReport
+	generated time.Time
+}
+
+// checkCost - check the usage trimming keeps the usage log entries of the current month
+// for the cost collector
+func checkCost(usageTrim *UsageTrimConfig, collectors []*namedCollector) error {
+	if usageTrim == nil || usageTrim.Retention >= costMinRetention {
+		return nil
+	}
+	for _, c := range collectors {
+		if c.name == "cost" {
+			return fmt.Errorf("the cost collector requires the retention of usage_trim to be at least %s",
+				costMinRetention)
+		}
+	}
+	return nil
+}
+
+func (c *costCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- estimatedCostDesc
+}
+
+func (c *costCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	report := c.report
+	if report == nil || !report.Start.Equal(start) || now.Sub(c.generated) >= c.interval {
+		var err error
+		report, err = generateReport(client, c.pricing, c.families, nil, start, start.AddDate(0, 1, 0), now)
+		if err != nil {
+			return nil, err
+		}
+		c.report, c.generated = report, now
+	}
+
+	type labelKey struct{ tenant, user string }
+	totals := make(map[labelKey]*reportLine)
+	keys := make([]labelKey, 0, len(report.Users))
+	for _, l := range report.Users {
+		if !c.filters.matchUser(radosgw.JoinUserId(l.Tenant, l.User)) {
+			continue
+		}
+		key := labelKey{}
+		key.tenant, key.user, _, _ = c.aggregation.labels(l.Tenant, l.User, "", "")
+		total, ok := totals[key]
+		if !ok {
+			total = &reportLine{}
+			totals[key] = total
+			keys = append(keys, key)
+		}
+		total.StorageCost += l.StorageCost
+		total.RequestCost += l.RequestCost
+		total.EgressCost += l.EgressCost
+	}
+	result := make([]prometheus.Metric, 0, 3*len(keys))
+	for _, key := range keys {
+		total := totals[key]
+		result = append(result,
+			prometheus.MustNewConstMetric(estimatedCostDesc, prometheus.GaugeValue,
+				total.StorageCost, key.tenant, key.user, costComponentStorage),
+			prometheus.MustNewConstMetric(estimatedCostDesc, prometheus.GaugeValue,
+				total.RequestCost, key.tenant, key.user, costComponentRequests),
+			prometheus.MustNewConstMetric(estimatedCostDesc, prometheus.GaugeValue,
+				total.EgressCost, key.tenant, key.user, costComponentEgress))
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+// collector_cost_test.go - test the collector of the estimated cost
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCostCollectorInterval(t *testing.T) {
+	cases := []struct {
+		name     string
+		interval time.Duration
+		requests int
+	}{
+		{"cached", time.Hour, 1},
+		{"generated every update", 0, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newFakeRadosgw(t)
+			f.setBuckets(testBucketStats("alice", "b1", bytesPerGB))
+			collector := &costCollector{
+				families: newOpFamilies(nil),
+				pricing:  testPricing,
+				interval: c.interval,
+			}
+			for i := 0; i < 2; i++ {
+				metrics, err := collector.Update(f.client)
+				if err != nil {
+					t.Fatalf("update the cost failed: %v", err)
+				}
+				if len(metrics) != 3 {
+					t.Fatalf("got %d metrics, want 3", len(metrics))
+				}
+				if desc := metrics[0].Desc().String(); !strings.Contains(desc, `"radosgw_estimated_cost_dollars"`) ||
+					!strings.Contains(desc, "[tenant user component]") {
+					t.Errorf("got the metric %s", desc)
+				}
+			}
+			if n := len(f.recorded("GET /admin/usage")); n != c.requests {
+				t.Errorf("got %d usage requests, want %d", n, c.requests)
+			}
+		})
+	}
+}
+
+func TestCheckCost(t *testing.T) {
+	collectors := []*namedCollector{{name: "cost"}}
+	if err := checkCost(&UsageTrimConfig{Retention: 7 * 24 * time.Hour}, collectors); err == nil {
+		t.Errorf("got no error of the short retention")
+	}
+	if err := checkCost(&UsageTrimConfig{Retention: costMinRetention}, collectors); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	if err := checkCost(&UsageTrimConfig{Retention: time.Hour}, nil); err != nil {
+		t.Errorf("got error %v without the cost collector", err)
+	}
+}
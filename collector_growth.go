@@ -0,0 +1,166 @@
+// collector_growth.go - implement the collector of capacity growth forecasting
+
+package main
+
+import (
+	"flag"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+var (
+	growthWindow = flag.Duration("collector.growth.window", 7*24*time.Hour,
+		"window of the bucket size samples to compute the growth rates")
+	growthSampleInterval = flag.Duration("collector.growth.sample.interval", time.Hour,
+		"min interval between the bucket size samples")
+	growthMethod = flag.String("collector.growth.method", growthMethodLinear,
+		"method to compute the growth rates, one of linear and holt")
+	growthStateDir = flag.String("collector.growth.state.dir", "",
+		"directory to persist the bucket size samples across restarts, empty means only in memory")
+
+	// bucketGrowthDesc shows the growth rate of the bucket size.
+	bucketGrowthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket", "growth_bytes_per_second"),
+		"growth rate of the bucket size in the window",
+		[]string{"tenant", "user", "bucket"}, nil)
+
+	// userGrowthDesc shows the growth rate of the total size of the buckets of the user.
+	userGrowthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "growth_bytes_per_second"),
+		"growth rate of the total size of the buckets of the user in the window",
+		[]string{"tenant", "user"}, nil)
+
+	// userQuotaExhaustionDesc shows when the user quota is projected to be exhausted.
+	userQuotaExhaustionDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "quota_exhaustion_seconds"),
+		"projected seconds until the max size of the user quota is exhausted, absent if not growing",
+		[]string{"tenant", "user"}, nil)
+)
+
+func init() {
+	registerCollector("growth", false, func(opts *collectorOptions) subCollector {
+		return &growthCollector{
+			filters:   opts.filters,
+			window:    *growthWindow,
+			interval:  *growthSampleInterval,
+			method:    *growthMethod,
+			ephemeral: opts.ephemeral,
+		}
+	})
+}
+
+// growthCollector samples the bucket sizes into a rolling window which may be longer than
+// the retention of prometheus, and forecasts the growth of each bucket and user from it.
+// It sends one more request per growing user, so it is disabled by default.
+type growthCollector struct {
+	filters  *labelFilters
+	window   time.Duration
+	interval time.Duration
+	method   string
+
+	// ephemeral keeps the state only in the collector, it is created by the first update.
+	ephemeral bool
+	state     *growthState
+}
+
+func (g *growthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bucketGrowthDesc
+	ch <- userGrowthDesc
+	ch <- userQuotaExhaustionDesc
+}
+
+// Update - the growth rate of a user is the sum of the rates of its buckets, and the user
+// quota is projected to be exhausted by the rate from the total size of the buckets.
+func (g *growthCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	status, bucketStats, err := client.GetBucket("", "", true)
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	sizes := make(map[growthKey]int64, len(bucketStats))
+	for i := range bucketStats {
+		stats := bucketStats[i].Stats
+		if stats == nil {
+			continue
+		}
+		tenant, _, bucket := tenantLabels(stats.Owner, stats.Bucket)
+		if len(stats.Tenant) != 0 {
+			tenant = stats.Tenant
+		}
+		key := growthKey{stats.Owner, radosgw.JoinBucketName(tenant, bucket)}
+		if !g.filters.matchBucket(key.Owner, key.Bucket) {
+			continue
+		}
+		sizes[key] = stats.Usage[radosgw.BucketUsageMain].Size
+	}
+	state := g.state
+	switch {
+	case !g.ephemeral:
+		state = growthStateFor(client.Endpoint())
+	case state == nil:
+		state = newGrowthState(client.Endpoint(), "")
+		g.state = state
+	}
+	rates, err := state.update(sizes, time.Now(), g.interval, g.window, g.method)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]growthKey, 0, len(rates))
+	for key := range rates {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Owner != keys[j].Owner {
+			return keys[i].Owner < keys[j].Owner
+		}
+		return keys[i].Bucket < keys[j].Bucket
+	})
+	result := make([]prometheus.Metric, 0, len(keys))
+	owners := make([]string, 0)
+	userRates := make(map[string]float64)
+	for _, key := range keys {
+		tenant, user, bucket := tenantLabels(key.Owner, key.Bucket)
+		result = append(result, prometheus.MustNewConstMetric(bucketGrowthDesc,
+			prometheus.GaugeValue, rates[key], tenant, user, bucket))
+		if _, ok := userRates[key.Owner]; !ok {
+			owners = append(owners, key.Owner)
+		}
+		userRates[key.Owner] += rates[key]
+	}
+	userSizes := make(map[string]int64)
+	for key, size := range sizes {
+		userSizes[key.Owner] += size
+	}
+	for _, owner := range owners {
+		tenant, user := radosgw.SplitUserId(owner)
+		rate := userRates[owner]
+		result = append(result, prometheus.MustNewConstMetric(userGrowthDesc,
+			prometheus.GaugeValue, rate, tenant, user))
+		if rate <= 0 {
+			continue
+		}
+		// The owners may be deleted after the buckets are listed
+		status, info, err := client.GetUser(owner)
+		if isNoSuchUser(status) {
+			continue
+		}
+		if err != nil || status > 200 {
+			return nil, newScrapeError(status, err)
+		}
+		quota := info.UserQuota
+		if !quota.Enabled || quota.MaxSize < 0 {
+			continue
+		}
+		seconds := float64(quota.MaxSize-userSizes[owner]) / rate
+		if seconds < 0 {
+			seconds = 0
+		}
+		result = append(result, prometheus.MustNewConstMetric(userQuotaExhaustionDesc,
+			prometheus.GaugeValue, seconds, tenant, user))
+	}
+	return result, nil
+}
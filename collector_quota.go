@@ -0,0 +1,184 @@
+// collector_quota.go - implement the collector of user and bucket quotas
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+var (
+	// userQuotaMaxBytesDesc shows the max size of the user quota.
+	userQuotaMaxBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_quota", "max_bytes"),
+		"max size in bytes of the user quota, absent if unlimited",
+		[]string{"tenant", "user"}, nil)
+
+	// userQuotaMaxObjectsDesc shows the max object number of the user quota.
+	userQuotaMaxObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_quota", "max_objects"),
+		"max object number of the user quota, absent if unlimited",
+		[]string{"tenant", "user"}, nil)
+
+	// userQuotaEnabledDesc shows whether the user quota is enabled.
+	userQuotaEnabledDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_quota", "enabled"),
+		"whether the user quota is enabled",
+		[]string{"tenant", "user"}, nil)
+
+	// userUsedBytesDesc shows the total size of all objects of the user.
+	userUsedBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "used_bytes"),
+		"total size in bytes of all objects of the user",
+		[]string{"tenant", "user"}, nil)
+
+	// userUsedObjectsDesc shows the total object number of the user.
+	userUsedObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "used_objects"),
+		"total object number of the user",
+		[]string{"tenant", "user"}, nil)
+
+	// bucketQuotaMaxBytesDesc shows the max size of the bucket quota.
+	bucketQuotaMaxBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket_quota", "max_bytes"),
+		"max size in bytes of the bucket quota, absent if unlimited",
+		[]string{"tenant", "user", "bucket"}, nil)
+
+	// bucketQuotaMaxObjectsDesc shows the max object number of the bucket quota.
+	bucketQuotaMaxObjectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket_quota", "max_objects"),
+		"max object number of the bucket quota, absent if unlimited",
+		[]string{"tenant", "user", "bucket"}, nil)
+
+	// bucketQuotaEnabledDesc shows whether the bucket quota is enabled.
+	bucketQuotaEnabledDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "bucket_quota", "enabled"),
+		"whether the bucket quota is enabled",
+		[]string{"tenant", "user", "bucket"}, nil)
+)
+
+func init() {
+	registerCollector("quota", false, func(opts *collectorOptions) subCollector {
+		return &quotaCollector{filters: opts.filters, notifier: opts.notifier}
+	})
+}
+
+// quotaCollector collects the quotas and storage stats of each user and the quota of
+// each bucket. It sends one request per user, so it is disabled by default. The quota
+// utilization is evaluated by the notifier after each collection if configured.
+type quotaCollector struct {
+	filters  *labelFilters
+	notifier *quotaNotifier
+}
+
+func (q *quotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- userQuotaMaxBytesDesc
+	ch <- userQuotaMaxObjectsDesc
+	ch <- userQuotaEnabledDesc
+	ch <- userUsedBytesDesc
+	ch <- userUsedObjectsDesc
+	ch <- bucketQuotaMaxBytesDesc
+	ch <- bucketQuotaMaxObjectsDesc
+	ch <- bucketQuotaEnabledDesc
+}
+
+func (q *quotaCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	status, uids, err := client.ListUsers()
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	result := make([]prometheus.Metric, 0)
+	utilizations := make([]quotaUtilization, 0)
+	for _, uid := range uids {
+		if !q.filters.matchUser(uid) {
+			continue
+		}
+		status, user, err := client.GetUserWithStats(uid)
+		if isNoSuchUser(status) {
+			continue
+		}
+		if err != nil || status > 200 {
+			return nil, newScrapeError(status, err)
+		}
+		tenant, name := radosgw.SplitUserId(uid)
+		result = appendQuotaMetrics(result, &user.UserQuota, userQuotaMaxBytesDesc,
+			userQuotaMaxObjectsDesc, userQuotaEnabledDesc, tenant, name)
+		if user.Stats != nil {
+			result = append(result,
+				prometheus.MustNewConstMetric(userUsedBytesDesc, prometheus.GaugeValue,
+					float64(user.Stats.Size), tenant, name),
+				prometheus.MustNewConstMetric(userUsedObjectsDesc, prometheus.GaugeValue,
+					float64(user.Stats.NumObjects), tenant, name))
+			if user.UserQuota.Enabled {
+				utilizations = append(utilizations, quotaUtilization{
+					Kind:        "user",
+					Tenant:      tenant,
+					User:        uid,
+					UsedBytes:   user.Stats.Size,
+					MaxBytes:    user.UserQuota.MaxSize,
+					UsedObjects: user.Stats.NumObjects,
+					MaxObjects:  user.UserQuota.MaxObjects,
+				})
+			}
+		}
+	}
+
+	status, bucketStats, err := client.GetBucket("", "", true)
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	for i := range bucketStats {
+		stats := bucketStats[i].Stats
+		if stats == nil {
+			continue
+		}
+		tenant, user, bucket := tenantLabels(stats.Owner, stats.Bucket)
+		if len(stats.Tenant) != 0 {
+			tenant = stats.Tenant
+		}
+		if !q.filters.matchBucket(stats.Owner, radosgw.JoinBucketName(tenant, bucket)) {
+			continue
+		}
+		result = appendQuotaMetrics(result, &stats.BucketQuota, bucketQuotaMaxBytesDesc,
+			bucketQuotaMaxObjectsDesc, bucketQuotaEnabledDesc, tenant, user, bucket)
+		if stats.BucketQuota.Enabled {
+			main := stats.Usage[radosgw.BucketUsageMain]
+			utilizations = append(utilizations, quotaUtilization{
+				Kind:        "bucket",
+				Tenant:      tenant,
+				User:        stats.Owner,
+				Bucket:      radosgw.JoinBucketName(tenant, bucket),
+				UsedBytes:   main.Size,
+				MaxBytes:    stats.BucketQuota.MaxSize,
+				UsedObjects: main.NumObjects,
+				MaxObjects:  stats.BucketQuota.MaxObjects,
+			})
+		}
+	}
+	q.notifier.evaluate(utilizations, time.Now())
+	return result, nil
+}
+
+// appendQuotaMetrics - append the metrics of the quota, the negative limits mean unlimited
+// so they are not exported
+func appendQuotaMetrics(result []prometheus.Metric, quota *radosgw.QuotaType, maxBytesDesc,
+	maxObjectsDesc, enabledDesc *prometheus.Desc, labels ...string) []prometheus.Metric {
+	enabled := 0.0
+	if quota.Enabled {
+		enabled = 1
+	}
+	result = append(result,
+		prometheus.MustNewConstMetric(enabledDesc, prometheus.GaugeValue, enabled, labels...))
+	if quota.MaxSize >= 0 {
+		result = append(result, prometheus.MustNewConstMetric(maxBytesDesc,
+			prometheus.GaugeValue, float64(quota.MaxSize), labels...))
+	}
+	if quota.MaxObjects >= 0 {
+		result = append(result, prometheus.MustNewConstMetric(maxObjectsDesc,
+			prometheus.GaugeValue, float64(quota.MaxObjects), labels...))
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+// collector_test.go - test the sub-collectors running framework
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+// blockingCollector produces the metrics once released, and counts the updates.
+type blockingCollector struct {
+	release chan struct{}
+	metrics []prometheus.Metric
+
+	mtx     sync.Mutex
+	updates int
+}
+
+func newBlockingCollector(n int) *blockingCollector {
+	c := &blockingCollector{release: make(chan struct{})}
+	for i := 0; i < n; i++ {
+		c.metrics = append(c.metrics, prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1))
+	}
+	return c
+}
+
+func (c *blockingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+}
+
+func (c *blockingCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	c.mtx.Lock()
+	c.updates++
+	c.mtx.Unlock()
+	<-c.release
+	return c.metrics, nil
+}
+
+func (c *blockingCollector) count() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.updates
+}
+
+// waitCallDone - wait for the running update of the collector to complete
+func waitCallDone(t *testing.T, c *namedCollector) {
+	t.Helper()
+	c.mtx.Lock()
+	call := c.call
+	c.mtx.Unlock()
+	if call == nil {
+		return
+	}
+	select {
+	case <-call.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the update of the collector never completed")
+	}
+}
+
+func TestNamedCollectorTimeout(t *testing.T) {
+	blocking := newBlockingCollector(1)
+	c := &namedCollector{name: "blocking", timeout: 10 * time.Millisecond, collector: blocking}
+
+	// The update running after a timeout is joined instead of starting another one
+	for i := 0; i < 3; i++ {
+		_, err := c.update(nil)
+		if e, ok := err.(*scrapeError); !ok || e.reason != "timeout" {
+			t.Fatalf("run %d: got error %v, want the timeout", i, err)
+		}
+	}
+	if n := blocking.count(); n != 1 {
+		t.Errorf("got %d updates of the slow collector, want 1", n)
+	}
+
+	close(blocking.release)
+	waitCallDone(t, c)
+	metrics, err := c.update(nil)
+	if err != nil || len(metrics) != 1 {
+		t.Errorf("got %d metrics and error %v after released, want 1 metric", len(metrics), err)
+	}
+	if n := blocking.count(); n != 2 {
+		t.Errorf("got %d updates, want a new one after the slow one completed", n)
+	}
+}
+
+func TestNamedCollectorJoin(t *testing.T) {
+	blocking := newBlockingCollector(1)
+	c := &namedCollector{name: "blocking", collector: blocking}
+
+	// The concurrent scrapes without the timeout share the same update
+	const scrapes = 5
+	results := make(chan error, scrapes)
+	for i := 0; i < scrapes; i++ {
+		go func() {
+			metrics, err := c.update(nil)
+			if err == nil && len(metrics) != 1 {
+				err = fmt.Errorf("got %d metrics, want 1", len(metrics))
+			}
+			results <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := blocking.count(); n != 1 {
+		t.Errorf("got %d updates of the concurrent scrapes, want 1", n)
+	}
+	close(blocking.release)
+	for i := 0; i < scrapes; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("got error %v", err)
+		}
+	}
+}
+
+func TestNamedCollectorSeriesLimit(t *testing.T) {
+	cases := []struct {
+		name      string
+		maxSeries int
+		err       bool
+	}{
+		{"unlimited", 0, false},
+		{"within the limit", 3, false},
+		{"beyond the limit", 2, true},
+	}
+	for _, c := range cases {
+		blocking := newBlockingCollector(3)
+		close(blocking.release)
+		collector := &namedCollector{name: "blocking", maxSeries: c.maxSeries, collector: blocking}
+		metrics, err := collector.update(nil)
+		if c.err {
+			if e, ok := err.(*scrapeError); !ok || e.reason != "series_limit" || metrics != nil {
+				t.Errorf("%s: got %d metrics and error %v, want the series limit", c.name, len(metrics), err)
+			}
+			continue
+		}
+		if err != nil || len(metrics) != 3 {
+			t.Errorf("%s: got %d metrics and error %v, want 3 metrics", c.name, len(metrics), err)
+		}
+	}
+}
+
+func TestScrapeErrorReason(t *testing.T) {
+	f := newFakeRadosgw(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+	client, err := radosgw.NewClientWithProvider(f.server.URL, radosgw.NewFileProvider(missing, missing))
+	if err != nil {
+		t.Fatalf("create the client failed: %v", err)
+	}
+
+	// The credentials failed locally are not blamed on radosgw
+	status, _, err := client.ListUsers()
+	if reason := newScrapeError(status, err).reason; reason != "credentials" {
+		t.Errorf("got reason %s of the credentials failure, want credentials", reason)
+	}
+	if n := len(f.recorded("GET /admin/metadata/user")); n != 0 {
+		t.Errorf("got %d requests sent without the credentials", n)
+	}
+
+	cases := []struct {
+		status int
+		want   string
+	}{
+		{500, "server_error"},
+		{403, "client_error"},
+		{302, "unexpected_status"},
+	}
+	for _, c := range cases {
+		if reason := newScrapeError(c.status, nil).reason; reason != c.want {
+			t.Errorf("got reason %s of status %d, want %s", reason, c.status, c.want)
+		}
+	}
+}
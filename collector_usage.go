@@ -0,0 +1,248 @@
+// collector_usage.go - implement the collector of API usage
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const (
+	usageOutputBuckets = "buckets"
+	usageOutputUsers   = "users"
+	usageOutputBoth    = "both"
+)
+
+var (
+	usageOutput = flag.String("collector.usage.output", usageOutputBoth,
+		"usage metrics exported by the usage collector, one of buckets, users and both, "+
+			"only the summary of each user is fetched if it is users")
+
+	// bytesSentDesc shows the total send throughput.
+	bytesSentDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "bytes_sent_total"),
+		"currently total sent throughput",
+		[]string{"tenant", "user", "bucket", "api", "op_family"}, nil)
+
+	// bytesRecvDesc shows the total received throughput.
+	bytesRecvDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "bytes_recv_total"),
+		"currently total recv throughput",
+		[]string{"tenant", "user", "bucket", "api", "op_family"}, nil)
+
+	// opsDesc shows the total operation called times.
+	opsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "ops_total"),
+		"currently total ops",
+		[]string{"tenant", "user", "bucket", "api", "op_family"}, nil)
+
+	// opsOKDesc shows the total operation called times successfully.
+	opsOKDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "ops_ok_total"),
+		"currently total ops ok",
+		[]string{"tenant", "user", "bucket", "api", "op_family"}, nil)
+
+	// opsFailedDesc shows the total operation called times unsuccessfully.
+	opsFailedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "", "ops_failed_total"),
+		"currently total ops failed",
+		[]string{"tenant", "user", "bucket", "api", "op_family"}, nil)
+
+	// userBytesSentDesc shows the total send throughput of each user.
+	userBytesSentDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_usage", "bytes_sent_total"),
+		"currently total sent throughput of the user",
+		[]string{"tenant", "user", "api", "op_family"}, nil)
+
+	// userBytesRecvDesc shows the total received throughput of each user.
+	userBytesRecvDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_usage", "bytes_recv_total"),
+		"currently total recv throughput of the user",
+		[]string{"tenant", "user", "api", "op_family"}, nil)
+
+	// userOpsDesc shows the total operation called times of each user.
+	userOpsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_usage", "ops_total"),
+		"currently total ops of the user",
+		[]string{"tenant", "user", "api", "op_family"}, nil)
+
+	// userOpsOKDesc shows the total operation called times successfully of each user.
+	userOpsOKDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_usage", "ops_ok_total"),
+		"currently total ops ok of the user",
+		[]string{"tenant", "user", "api", "op_family"}, nil)
+
+	// userOpsFailedDesc shows the total operation called times unsuccessfully of each user.
+	userOpsFailedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user_usage", "ops_failed_total"),
+		"currently total ops failed of the user",
+		[]string{"tenant", "user", "api", "op_family"}, nil)
+
+	// usageCheckpointDesc shows the end of the hours accumulated as final.
+	usageCheckpointDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "exporter", "usage_checkpoint_timestamp_seconds"),
+		"unix timestamp before which the usage log entries are accumulated as final",
+		nil, nil)
+
+	// usageReconciledDesc counts the usage log entries found trimmed.
+	usageReconciledDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "exporter", "usage_reconciled_entries_total"),
+		"total number of usage log entries trimmed before being accumulated as final",
+		nil, nil)
+)
+
+func init() {
+	registerCollector("usage", true, func(opts *collectorOptions) subCollector {
+		return &usageCollector{
+			filters:     opts.filters,
+			aggregation: opts.aggregation,
+			families:    opts.families,
+			output:      *usageOutput,
+			ephemeral:   opts.ephemeral,
+		}
+	})
+}
+
+// usageCollector collects the API usage data by users.
+type usageCollector struct {
+	filters     *labelFilters
+	aggregation aggregation
+	families    opFamilies
+	output      string
+
+	// ephemeral keeps the state only in the collector, it is created by the first update.
+	ephemeral bool
+	state     *usageState
+}
+
+func (u *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	if u.output != usageOutputUsers {
+		ch <- bytesSentDesc
+		ch <- bytesRecvDesc
+		ch <- opsDesc
+		ch <- opsOKDesc
+		ch <- opsFailedDesc
+	}
+	if u.output != usageOutputBuckets {
+		ch <- userBytesSentDesc
+		ch <- userBytesRecvDesc
+		ch <- userOpsDesc
+		ch <- userOpsOKDesc
+		ch <- userOpsFailedDesc
+	}
+	ch <- usageCheckpointDesc
+	ch <- usageReconciledDesc
+}
+
+// Update - the usage log has one entry per bucket and hour, so only the entries after
+// the checkpoint are fetched and accumulated to the totals of each user, bucket and api,
+// which are then summed up to the labels kept by the aggregation and the operation
+// family of the api. Only the summary of each user is fetched instead if the users are
+// the only output.
+func (u *usageCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	state := u.state
+	switch {
+	case !u.ephemeral:
+		state = usageStateFor(client.Endpoint())
+	case state == nil:
+		state = newUsageState(client.Endpoint(), "")
+		u.state = state
+	}
+	snapshot, err := state.update(client, time.Now(), u.output)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]prometheus.Metric, 0)
+	if snapshot.totals != nil {
+		result = u.appendBucketMetrics(result, snapshot.totals)
+	}
+	if snapshot.userTotals != nil {
+		result = u.appendUserMetrics(result, snapshot.userTotals)
+	}
+	result = append(result,
+		prometheus.MustNewConstMetric(usageCheckpointDesc, prometheus.GaugeValue,
+			float64(snapshot.checkpoint.Unix())),
+		prometheus.MustNewConstMetric(usageReconciledDesc, prometheus.CounterValue,
+			float64(snapshot.reconciled)))
+	return result, nil
+}
+
+func (u *usageCollector) appendBucketMetrics(result []prometheus.Metric,
+	totals map[usageKey]usageValue) []prometheus.Metric {
+	type labelKey struct{ tenant, user, bucket, api, family string }
+	sums := make(map[labelKey]*usageValue)
+	keys := make([]labelKey, 0)
+	for _, usage := range sortedUsageKeys(totals) {
+		tenant, user, bucket := tenantLabels(usage.User, usage.Bucket)
+		if !u.filters.matchBucket(usage.User, radosgw.JoinBucketName(tenant, bucket)) ||
+			!u.filters.matchCategory(usage.Category) {
+			continue
+		}
+		key := labelKey{family: u.families.family(usage.Category)}
+		key.tenant, key.user, key.bucket, key.api = u.aggregation.labels(
+			tenant, user, bucket, usage.Category)
+		sum, ok := sums[key]
+		if !ok {
+			sum = &usageValue{}
+			sums[key] = sum
+			keys = append(keys, key)
+		}
+		sum.add(totals[usage])
+	}
+	for _, key := range keys {
+		result = appendUsageMetrics(result, sums[key], bytesSentDesc, bytesRecvDesc, opsDesc,
+			opsOKDesc, opsFailedDesc, key.tenant, key.user, key.bucket, key.api, key.family)
+	}
+	return result
+}
+
+// appendUserMetrics - append the usage metrics of each user, the bucket aggregation only
+// keeps the tenant label of them
+func (u *usageCollector) appendUserMetrics(result []prometheus.Metric,
+	totals map[usageKey]usageValue) []prometheus.Metric {
+	type labelKey struct{ tenant, user, api, family string }
+	sums := make(map[labelKey]*usageValue)
+	keys := make([]labelKey, 0)
+	for _, usage := range sortedUsageKeys(totals) {
+		if !u.filters.matchUser(usage.User) || !u.filters.matchCategory(usage.Category) {
+			continue
+		}
+		tenant, user := radosgw.SplitUserId(usage.User)
+		key := labelKey{family: u.families.family(usage.Category)}
+		key.tenant, key.user, _, key.api = u.aggregation.labels(tenant, user, "", usage.Category)
+		sum, ok := sums[key]
+		if !ok {
+			sum = &usageValue{}
+			sums[key] = sum
+			keys = append(keys, key)
+		}
+		sum.add(totals[usage])
+	}
+	for _, key := range keys {
+		result = appendUsageMetrics(result, sums[key], userBytesSentDesc, userBytesRecvDesc,
+			userOpsDesc, userOpsOKDesc, userOpsFailedDesc, key.tenant, key.user, key.api, key.family)
+	}
+	return result
+}
+
+// appendUsageMetrics - append the usage metrics of the total, the failed ops are the ones
+// not successful. The totals are accumulated so they are counters.
+func appendUsageMetrics(result []prometheus.Metric, total *usageValue, bytesSentDesc,
+	bytesRecvDesc, opsDesc, opsOKDesc, opsFailedDesc *prometheus.Desc,
+	labels ...string) []prometheus.Metric {
+	return append(result,
+		prometheus.MustNewConstMetric(bytesSentDesc, prometheus.CounterValue,
+			float64(total.BytesSent), labels...),
+		prometheus.MustNewConstMetric(bytesRecvDesc, prometheus.CounterValue,
+			float64(total.BytesReceived), labels...),
+		prometheus.MustNewConstMetric(opsDesc, prometheus.CounterValue,
+			float64(total.Ops), labels...),
+		prometheus.MustNewConstMetric(opsOKDesc, prometheus.CounterValue,
+			float64(total.SuccessfulOps), labels...),
+		prometheus.MustNewConstMetric(opsFailedDesc, prometheus.CounterValue,
+			float64(total.Ops-total.SuccessfulOps), labels...))
+}
@@ -0,0 +1,51 @@
+// collector_usage_test.go - test the collector of the usage log
+
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestUsageCollectorCounters(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.setUsage(testUsage(9, 10, 9))
+	collector := &usageCollector{families: newOpFamilies(nil), output: usageOutputBoth, ephemeral: true}
+	metrics, err := collector.Update(f.client)
+	if err != nil {
+		t.Fatalf("update the usage failed: %v", err)
+	}
+	failed := map[string]float64{
+		`api="get_obj",bucket="b1",op_family="read",tenant="",user="alice"`: 1,
+	}
+	if got := seriesOf(t, metrics, opsFailedDesc); !equalSeries(got, failed) {
+		t.Errorf("got failed ops %v, want %v", got, failed)
+	}
+
+	// The accumulated totals are counters for rate() and the reset handling
+	totals := map[string]bool{
+		bytesSentDesc.String(): true, bytesRecvDesc.String(): true, opsDesc.String(): true,
+		opsOKDesc.String(): true, opsFailedDesc.String(): true, userBytesSentDesc.String(): true,
+		userBytesRecvDesc.String(): true, userOpsDesc.String(): true, userOpsOKDesc.String(): true,
+		userOpsFailedDesc.String(): true,
+	}
+	counters := 0
+	for _, m := range metrics {
+		if !totals[m.Desc().String()] {
+			continue
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("write the metric failed: %v", err)
+		}
+		if pb.Counter == nil {
+			t.Errorf("got the total %s not a counter", m.Desc())
+			continue
+		}
+		counters++
+	}
+	if counters != len(totals) {
+		t.Errorf("got %d counters of the totals, want %d", counters, len(totals))
+	}
+}
@@ -0,0 +1,139 @@
+// collector_user.go - implement the collector of user inventory
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const (
+	emailNone  = "none"
+	emailHash  = "hash"
+	emailPlain = "plain"
+)
+
+var (
+	userEmail = flag.String("collector.user.email", emailNone,
+		"how to export the email of the users in radosgw_user_info, one of none, hash and plain")
+
+	// userInfoDesc shows the display name and email of the user.
+	userInfoDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "info"),
+		"information of the user, always 1",
+		[]string{"tenant", "user", "display_name", "email"}, nil)
+
+	// userSuspendedDesc shows whether the user is suspended.
+	userSuspendedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "suspended"),
+		"whether the user is suspended",
+		[]string{"tenant", "user"}, nil)
+
+	// userMaxBucketsDesc shows the max bucket number of the user.
+	userMaxBucketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "max_buckets"),
+		"max bucket number the user can create",
+		[]string{"tenant", "user"}, nil)
+
+	// userBucketsDesc shows the bucket number owned by the user.
+	userBucketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "buckets"),
+		"current bucket number owned by the user",
+		[]string{"tenant", "user"}, nil)
+
+	// userKeysDesc shows the access key number of the user.
+	userKeysDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "keys"),
+		"access key number of the user",
+		[]string{"tenant", "user"}, nil)
+
+	// userCapsDesc shows the admin capabilities of the user.
+	userCapsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(radosgwNamespace, "user", "caps"),
+		"admin capability granted to the user, always 1",
+		[]string{"tenant", "user", "type", "perm"}, nil)
+)
+
+func init() {
+	registerCollector("user", false, func(opts *collectorOptions) subCollector {
+		return &userCollector{filters: opts.filters, email: *userEmail}
+	})
+}
+
+// userCollector collects the information, keys and capabilities of each user. It sends
+// two requests per user, so it is disabled by default.
+type userCollector struct {
+	filters *labelFilters
+	email   string
+}
+
+func (u *userCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- userInfoDesc
+	ch <- userSuspendedDesc
+	ch <- userMaxBucketsDesc
+	ch <- userBucketsDesc
+	ch <- userKeysDesc
+	ch <- userCapsDesc
+}
+
+func (u *userCollector) Update(client *radosgw.Client) ([]prometheus.Metric, error) {
+	status, uids, err := client.ListUsers()
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	result := make([]prometheus.Metric, 0, 6*len(uids))
+	for _, uid := range uids {
+		if !u.filters.matchUser(uid) {
+			continue
+		}
+		// The users may be deleted after being listed
+		status, user, err := client.GetUser(uid)
+		if isNoSuchUser(status) {
+			continue
+		}
+		if err != nil || status > 200 {
+			return nil, newScrapeError(status, err)
+		}
+		status, buckets, err := client.GetBucket("", uid, false)
+		if isNoSuchUser(status) {
+			continue
+		}
+		if err != nil || status > 200 {
+			return nil, newScrapeError(status, err)
+		}
+		tenant, name := radosgw.SplitUserId(uid)
+		result = append(result,
+			prometheus.MustNewConstMetric(userInfoDesc, prometheus.GaugeValue, 1,
+				tenant, name, user.DisplayName, u.emailLabel(user.Email)),
+			prometheus.MustNewConstMetric(userSuspendedDesc, prometheus.GaugeValue,
+				float64(user.Suspended), tenant, name),
+			prometheus.MustNewConstMetric(userMaxBucketsDesc, prometheus.GaugeValue,
+				float64(user.MaxBuckets), tenant, name),
+			prometheus.MustNewConstMetric(userBucketsDesc, prometheus.GaugeValue,
+				float64(len(buckets)), tenant, name),
+			prometheus.MustNewConstMetric(userKeysDesc, prometheus.GaugeValue,
+				float64(len(user.Keys)), tenant, name))
+		for _, c := range user.Caps {
+			result = append(result, prometheus.MustNewConstMetric(userCapsDesc,
+				prometheus.GaugeValue, 1, tenant, name, c.Type, c.Perm))
+		}
+	}
+	return result, nil
+}
+
+// emailLabel - get the email label value by the -collector.user.email mode
+func (u *userCollector) emailLabel(email string) string {
+	switch {
+	case len(email) == 0 || u.email == emailPlain:
+		return email
+	case u.email == emailHash:
+		sum := sha256.Sum256([]byte(email))
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
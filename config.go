@@ -0,0 +1,556 @@
+// config.go - define the configuration file of the radosgw exporter
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+// Config stands for the content of the configuration file
+type Config struct {
+	// Clusters are the radosgw services collected by the metrics path.
+	Clusters []ClusterConfig `yaml:"clusters"`
+
+	// AuthModules are the named credentials used by the /probe endpoint.
+	AuthModules map[string]AuthModuleConfig `yaml:"auth_modules"`
+
+	// Collectors overrides the collector flags for all clusters and probes.
+	Collectors map[string]CollectorConfig `yaml:"collectors"`
+
+	// Filters are the label filters for all clusters and probes.
+	Filters FiltersConfig `yaml:"filters"`
+
+	// Limits bound the cardinality of all clusters and probes.
+	Limits LimitsConfig `yaml:"limits"`
+
+	// OpFamilies maps the usage categories to the operation families, overriding the
+	// default mapping.
+	OpFamilies map[string]string `yaml:"op_families"`
+
+	// UsageTrim trims the usage log of all clusters, it is disabled if not given.
+	UsageTrim *UsageTrimConfig `yaml:"usage_trim"`
+
+	// InventoryEvents watches the changes of the users and buckets of all clusters, it is
+	// disabled if not given.
+	InventoryEvents *InventoryEventsConfig `yaml:"inventory_events"`
+
+	// QuotaNotifications notifies the quota utilization of all clusters, it is disabled if
+	// not given.
+	QuotaNotifications *QuotaNotificationsConfig `yaml:"quota_notifications"`
+
+	// Policies applies the admin rules to the users of all clusters, it is disabled if not
+	// given.
+	Policies *PoliciesConfig `yaml:"policies"`
+
+	// Pricing is the price book of the chargeback reports.
+	Pricing PricingConfig `yaml:"pricing"`
+}
+
+// ClusterConfig describes a radosgw service to be collected
+type ClusterConfig struct {
+	Name               string                     `yaml:"name"`
+	Endpoint           string                     `yaml:"endpoint"`
+	Credentials        Credentials                `yaml:"credentials"`
+	PollInterval       time.Duration              `yaml:"poll_interval"`
+	Collectors         map[string]CollectorConfig `yaml:"collectors"`
+	Filters            *FiltersConfig             `yaml:"filters"`
+	Limits             *LimitsConfig              `yaml:"limits"`
+	UsageTrim          *UsageTrimConfig           `yaml:"usage_trim"`
+	InventoryEvents    *InventoryEventsConfig     `yaml:"inventory_events"`
+	QuotaNotifications *QuotaNotificationsConfig  `yaml:"quota_notifications"`
+	Policies           *PoliciesConfig            `yaml:"policies"`
+}
+
+// AuthModuleConfig is the credentials used by the /probe endpoint for the allowed targets
+type AuthModuleConfig struct {
+	Credentials `yaml:",inline"`
+
+	// Targets are the regular expressions matching the whole target URLs which can be
+	// probed with the credentials, such as "http://rgw-[ab]:8080".
+	Targets []string `yaml:"targets"`
+}
+
+// allowTarget - check whether the target URL matches any of the targets
+func (a *AuthModuleConfig) allowTarget(target string) bool {
+	for _, pattern := range a.Targets {
+		if ok, err := regexp.MatchString("^(?:"+pattern+")$", target); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AuthModuleConfig) validate() error {
+	if _, err := a.provider(); err != nil {
+		return err
+	}
+	if len(a.Targets) == 0 {
+		return fmt.Errorf("targets should not be empty")
+	}
+	for _, pattern := range a.Targets {
+		if _, err := regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			return fmt.Errorf("invalid target %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Credentials is the admin AK/SK given inline, by environment variables, by files or by
+// a profile of the AWS shared credentials file, only one of them can be used
+type Credentials struct {
+	AccessKey             string `yaml:"access_key"`
+	SecretKey             string `yaml:"secret_key"`
+	AccessKeyEnv          string `yaml:"access_key_env"`
+	SecretKeyEnv          string `yaml:"secret_key_env"`
+	AccessKeyFile         string `yaml:"access_key_file"`
+	SecretKeyFile         string `yaml:"secret_key_file"`
+	SharedCredentialsFile string `yaml:"shared_credentials_file"`
+	Profile               string `yaml:"profile"`
+}
+
+// CollectorConfig overrides the enabled state and timeout flags of a collector
+type CollectorConfig struct {
+	Enabled *bool         `yaml:"enabled"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// FiltersConfig selects the users, buckets and usage categories to be exported
+type FiltersConfig struct {
+	Users      FilterConfig `yaml:"users"`
+	Buckets    FilterConfig `yaml:"buckets"`
+	Categories FilterConfig `yaml:"categories"`
+}
+
+// FilterConfig has the regular expressions to match the whole label value, a value is
+// exported if it matches the include one and does not match the exclude one.
+type FilterConfig struct {
+	Include string `yaml:"include"`
+	Exclude string `yaml:"exclude"`
+}
+
+// LimitsConfig bounds the number of series exported by each collector
+type LimitsConfig struct {
+	// MaxSeries fails the collector producing more series than it, 0 means no limit.
+	MaxSeries int `yaml:"max_series"`
+
+	// Aggregation is one of user, bucket and category to only keep that label by
+	// summing up the series, all labels are kept if it is empty.
+	Aggregation string `yaml:"aggregation"`
+
+	// TopBuckets only exports the largest buckets by TopBucketsBy, which is size or
+	// objects, and sums up the other ones, 0 means exporting all buckets.
+	TopBuckets   int    `yaml:"top_buckets"`
+	TopBucketsBy string `yaml:"top_buckets_by"`
+}
+
+func (l *LimitsConfig) validate() error {
+	if l.MaxSeries < 0 {
+		return fmt.Errorf("max_series should not be negative")
+	}
+	if !aggregation(l.Aggregation).valid() {
+		return fmt.Errorf("unknown aggregation %s, should be one of user, bucket and category", l.Aggregation)
+	}
+	if l.TopBuckets < 0 {
+		return fmt.Errorf("top_buckets should not be negative")
+	}
+	if l.TopBuckets > 0 && aggregation(l.Aggregation) != aggregateNone &&
+		aggregation(l.Aggregation) != aggregateBucket {
+		return fmt.Errorf("top_buckets can not be used with the %s aggregation", l.Aggregation)
+	}
+	switch l.TopBucketsBy {
+	case "", topBySize, topByObjects:
+	default:
+		return fmt.Errorf("unknown top_buckets_by %s, should be size or objects", l.TopBucketsBy)
+	}
+	return nil
+}
+
+// UsageTrimConfig trims the usage log entries older than the retention, which have been
+// accumulated to the persisted totals of the usage collector
+type UsageTrimConfig struct {
+	// Retention is how long the usage log entries are kept, it should be positive.
+	Retention time.Duration `yaml:"retention"`
+
+	// Interval is the period of trimming, it is one hour if not given.
+	Interval time.Duration `yaml:"interval"`
+
+	// DryRun only counts the entries to be trimmed without deleting them.
+	DryRun bool `yaml:"dry_run"`
+
+	// Users limits the trimming to these user ids, all users are trimmed if empty.
+	Users []string `yaml:"users"`
+}
+
+func (u *UsageTrimConfig) validate() error {
+	if u.Retention <= 0 {
+		return fmt.Errorf("usage_trim: retention should be positive")
+	}
+	if u.Interval < 0 {
+		return fmt.Errorf("usage_trim: interval should not be negative")
+	}
+	for _, uid := range u.Users {
+		if len(uid) == 0 {
+			return fmt.Errorf("usage_trim: user should not be empty")
+		}
+	}
+	return nil
+}
+
+// InventoryEventsConfig watches the changes of the users and buckets and sends them as
+// events to a JSON-lines file or a webhook
+type InventoryEventsConfig struct {
+	// Interval is the period of comparing the inventory, it is five minutes if not given.
+	Interval time.Duration `yaml:"interval"`
+
+	// File is the JSON-lines file the events are appended to, "-" means stdout.
+	File string `yaml:"file"`
+
+	// WebhookURL receives the events of each interval as a JSON array by POST.
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+func (e *InventoryEventsConfig) validate() error {
+	if e.Interval < 0 {
+		return fmt.Errorf("inventory_events: interval should not be negative")
+	}
+	if (len(e.File) == 0) == (len(e.WebhookURL) == 0) {
+		return fmt.Errorf("inventory_events: exactly one of file and webhook_url should be given")
+	}
+	return nil
+}
+
+// QuotaNotificationsConfig notifies a webhook when the quota utilization of a user or
+// bucket crosses the thresholds, the utilization is evaluated by the quota collector
+type QuotaNotificationsConfig struct {
+	// WebhookURL receives each notification by POST.
+	WebhookURL string `yaml:"webhook_url"`
+
+	// Template is the text/template of the body, the notification is sent as JSON if
+	// not given.
+	Template string `yaml:"template"`
+
+	// ContentType is the content type of the body, it is application/json if not given
+	// without the template, or text/plain with it.
+	ContentType string `yaml:"content_type"`
+
+	// Thresholds are the ratios of the used to the max size or objects of the quota.
+	Thresholds []float64 `yaml:"thresholds"`
+
+	// MaxRetries is the number of retries of a failed notification, it is 5 if not given.
+	MaxRetries int `yaml:"max_retries"`
+
+	// InitialBackoff is the delay before the first retry which is doubled for each next
+	// one, it is one second if not given.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+}
+
+func (q *QuotaNotificationsConfig) validate() error {
+	if len(q.WebhookURL) == 0 {
+		return fmt.Errorf("quota_notifications: webhook_url should not be empty")
+	}
+	if len(q.Thresholds) == 0 {
+		return fmt.Errorf("quota_notifications: thresholds should not be empty")
+	}
+	for _, threshold := range q.Thresholds {
+		if threshold <= 0 {
+			return fmt.Errorf("quota_notifications: threshold %v should be positive", threshold)
+		}
+	}
+	if q.MaxRetries < 0 || q.InitialBackoff < 0 {
+		return fmt.Errorf("quota_notifications: max_retries and initial_backoff should not be negative")
+	}
+	if _, err := template.New("quota").Parse(q.Template); err != nil {
+		return fmt.Errorf("quota_notifications: invalid template: %v", err)
+	}
+	return nil
+}
+
+// PoliciesConfig applies the admin rules to the users periodically, every mutation is
+// recorded to the audit log
+type PoliciesConfig struct {
+	// Interval is the period of applying the rules, it is five minutes if not given.
+	Interval time.Duration `yaml:"interval"`
+
+	// DryRun only records the mutations to the audit log without performing them.
+	DryRun bool `yaml:"dry_run"`
+
+	// AuditLog is the JSON-lines file the mutations are appended to, "-" means stdout.
+	AuditLog string `yaml:"audit_log"`
+
+	// Rules are applied in order.
+	Rules []PolicyRuleConfig `yaml:"rules"`
+}
+
+// PolicyRuleConfig is a rule of the policies, the fields used depend on the action
+type PolicyRuleConfig struct {
+	// Name identifies the rule in the audit log and metrics.
+	Name string `yaml:"name"`
+
+	// Action is one of set_user_quota and suspend_user.
+	Action string `yaml:"action"`
+
+	// Users limits the rule to the matched user ids, all users are matched if empty.
+	Users FilterConfig `yaml:"users"`
+
+	// NewUsersOnly only applies set_user_quota to the users created after the rules
+	// started, otherwise to all users. It only applies to the users without an enabled
+	// user quota anyway.
+	NewUsersOnly bool `yaml:"new_users_only"`
+
+	// MaxSize and MaxObjects are the user quota set by set_user_quota, 0 means unlimited.
+	MaxSize    int64 `yaml:"max_size"`
+	MaxObjects int64 `yaml:"max_objects"`
+
+	// FailedRatio is the ratio of the failed operations of a user in the usage log between
+	// two intervals, beyond which the user is suspended by suspend_user.
+	FailedRatio float64 `yaml:"failed_ratio"`
+
+	// MinOps ignores the users with fewer operations than it since the ratio exceeded.
+	MinOps int64 `yaml:"min_ops"`
+
+	// For is how long the failed ratio should keep exceeded in the consecutive intervals
+	// before suspending.
+	For time.Duration `yaml:"for"`
+}
+
+func (p *PoliciesConfig) validate() error {
+	if p.Interval < 0 {
+		return fmt.Errorf("policies: interval should not be negative")
+	}
+	if len(p.AuditLog) == 0 {
+		return fmt.Errorf("policies: audit_log should not be empty")
+	}
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("policies: rules should not be empty")
+	}
+	names := make(map[string]bool)
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if len(r.Name) == 0 {
+			return fmt.Errorf("policies: rule %d: name should not be empty", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("policies: rule %s: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+		if _, err := newLabelFilter("users", r.Users); err != nil {
+			return fmt.Errorf("policies: rule %s: %v", r.Name, err)
+		}
+		switch r.Action {
+		case policySetUserQuota:
+			if r.MaxSize < 0 || r.MaxObjects < 0 || r.MaxSize+r.MaxObjects == 0 {
+				return fmt.Errorf("policies: rule %s: max_size or max_objects should be positive", r.Name)
+			}
+		case policySuspendUser:
+			if r.FailedRatio <= 0 || r.FailedRatio > 1 {
+				return fmt.Errorf("policies: rule %s: failed_ratio should be in (0, 1]", r.Name)
+			}
+			if r.MinOps < 0 || r.For < 0 {
+				return fmt.Errorf("policies: rule %s: min_ops and for should not be negative", r.Name)
+			}
+		default:
+			return fmt.Errorf("policies: rule %s: unknown action %s, should be %s or %s",
+				r.Name, r.Action, policySetUserQuota, policySuspendUser)
+		}
+	}
+	return nil
+}
+
+// PricingConfig is the price book of the chargeback reports, a GB is 2^30 bytes and a
+// month is 730 hours
+type PricingConfig struct {
+	// Currency is only shown in the reports.
+	Currency string `yaml:"currency"`
+
+	// StorageGBMonth is the price of storing a GB for a month.
+	StorageGBMonth float64 `yaml:"storage_gb_month"`
+
+	// EgressGB is the price of a GB sent by radosgw.
+	EgressGB float64 `yaml:"egress_gb"`
+
+	// Requests are the prices of 1000 requests by the operation family.
+	Requests map[string]float64 `yaml:"requests_per_1k"`
+}
+
+func (p *PricingConfig) validate() error {
+	if p.StorageGBMonth < 0 || p.EgressGB < 0 {
+		return fmt.Errorf("pricing: prices should not be negative")
+	}
+	for family, price := range p.Requests {
+		if price < 0 {
+			return fmt.Errorf("pricing: price of %s requests should not be negative", family)
+		}
+	}
+	return nil
+}
+
+// LoadConfig - load and validate the configuration file
+func LoadConfig(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parse config file %s failed: %v", filename, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	for name, module := range c.AuthModules {
+		if err := module.validate(); err != nil {
+			return fmt.Errorf("auth module %s: %v", name, err)
+		}
+	}
+	if err := validateCollectors(c.Collectors); err != nil {
+		return err
+	}
+	if _, err := newLabelFilters(&c.Filters); err != nil {
+		return err
+	}
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	for category, family := range c.OpFamilies {
+		if len(category) == 0 || len(family) == 0 {
+			return fmt.Errorf("op_families: category and family should not be empty")
+		}
+	}
+	if c.UsageTrim != nil {
+		if err := c.UsageTrim.validate(); err != nil {
+			return err
+		}
+	}
+	if c.InventoryEvents != nil {
+		if err := c.InventoryEvents.validate(); err != nil {
+			return err
+		}
+	}
+	if c.QuotaNotifications != nil {
+		if err := c.QuotaNotifications.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Policies != nil {
+		if err := c.Policies.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Pricing.validate(); err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i := range c.Clusters {
+		cluster := &c.Clusters[i]
+		if len(cluster.Name) == 0 {
+			return fmt.Errorf("cluster %d: name should not be empty", i)
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("cluster %s: duplicate name", cluster.Name)
+		}
+		names[cluster.Name] = true
+		if len(cluster.Endpoint) == 0 {
+			return fmt.Errorf("cluster %s: endpoint should not be empty", cluster.Name)
+		}
+		if cluster.PollInterval < 0 {
+			return fmt.Errorf("cluster %s: poll_interval should not be negative", cluster.Name)
+		}
+		if _, err := cluster.Credentials.provider(); err != nil {
+			return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		if err := validateCollectors(cluster.Collectors); err != nil {
+			return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		if cluster.Filters != nil {
+			if _, err := newLabelFilters(cluster.Filters); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		if cluster.Limits != nil {
+			if err := cluster.Limits.validate(); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		if cluster.UsageTrim != nil {
+			if err := cluster.UsageTrim.validate(); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		if cluster.InventoryEvents != nil {
+			if err := cluster.InventoryEvents.validate(); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		if cluster.QuotaNotifications != nil {
+			if err := cluster.QuotaNotifications.validate(); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		if cluster.Policies != nil {
+			if err := cluster.Policies.validate(); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateCollectors(collectors map[string]CollectorConfig) error {
+	for name, c := range collectors {
+		if findCollectorEntry(name) == nil {
+			return fmt.Errorf("unknown collector %s", name)
+		}
+		if c.Timeout < 0 {
+			return fmt.Errorf("collector %s: timeout should not be negative", name)
+		}
+	}
+	return nil
+}
+
+// provider - create the credentials provider and check it by retrieving once
+func (c *Credentials) provider() (radosgw.CredentialsProvider, error) {
+	var result radosgw.CredentialsProvider
+	given := 0
+	if len(c.AccessKey) != 0 || len(c.SecretKey) != 0 {
+		result = radosgw.NewStaticProvider(c.AccessKey, c.SecretKey)
+		given++
+	}
+	if len(c.AccessKeyEnv) != 0 || len(c.SecretKeyEnv) != 0 {
+		if len(c.AccessKeyEnv) == 0 || len(c.SecretKeyEnv) == 0 {
+			return nil, fmt.Errorf("access_key_env and secret_key_env should be set together")
+		}
+		result = radosgw.NewEnvProvider(c.AccessKeyEnv, c.SecretKeyEnv)
+		given++
+	}
+	if len(c.AccessKeyFile) != 0 || len(c.SecretKeyFile) != 0 {
+		if len(c.AccessKeyFile) == 0 || len(c.SecretKeyFile) == 0 {
+			return nil, fmt.Errorf("access_key_file and secret_key_file should be set together")
+		}
+		result = radosgw.NewFileProvider(c.AccessKeyFile, c.SecretKeyFile)
+		given++
+	}
+	if len(c.SharedCredentialsFile) != 0 || len(c.Profile) != 0 {
+		result = radosgw.NewSharedCredentialsProvider(c.SharedCredentialsFile, c.Profile)
+		given++
+	}
+	if given != 1 {
+		return nil, fmt.Errorf("credentials should be given by exactly one of the inline keys, " +
+			"environment variables, files or shared credentials profile")
+	}
+	if _, err := result.Retrieve(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -3,53 +3,141 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
 )
 
-const keepAlivePeriod = 10 * time.Minute
+const (
+	keepAlivePeriod = 10 * time.Minute
+	shutdownTimeout = 30 * time.Second
+
+	defaultProbeModule = "default"
+)
 
 var (
-	listenAddr  = flag.String("addr", "127.0.0.1:9129", "listen address for radosgw exporter")
-	metricsPath = flag.String("path", "/metrics", "URL path for collecting radosgw metrics")
-	adminAK     = flag.String("ak", "", "access key id of the admin user of radosgw service")
-	adminSK     = flag.String("sk", "", "secret access key of the admin user of radosgw service")
-	endpoint    = flag.String("endpoint", "127.0.0.1:8080", "endpoint of the radosgw service")
+	listenAddr   = flag.String("addr", "127.0.0.1:9129", "listen address for radosgw exporter")
+	metricsPath  = flag.String("path", "/metrics", "URL path for collecting radosgw metrics")
+	adminAK      = flag.String("ak", "", "access key id of the admin user of radosgw service, visible in ps output")
+	adminSK      = flag.String("sk", "", "secret access key of the admin user of radosgw service, visible in ps output")
+	adminAKFile  = flag.String("ak.file", "", "file containing the access key id, read again once changed")
+	adminSKFile  = flag.String("sk.file", "", "file containing the secret access key, read again once changed")
+	sharedFile   = flag.String("credentials.file", "", "AWS shared credentials file (default ~/.aws/credentials)")
+	profile      = flag.String("credentials.profile", "", "profile of the AWS shared credentials file to use")
+	endpoint     = flag.String("endpoint", "127.0.0.1:8080", "endpoint of the radosgw service")
+	webConfig    = flag.String("web.config.file", "", "path of the web configuration file enabling TLS or basic auth")
+	configFile   = flag.String("config.file", "", "path of the configuration file, reloaded on SIGHUP or POST /-/reload")
+	pollInterval = flag.Duration("poll.interval", 0,
+		"interval to poll radosgw in background and serve metrics from cache, 0 means collecting on each scrape")
+	logLevel  = flag.String("log.level", "info", "only log messages with the given severity or above, one of debug, info, warn and error")
+	logFormat = flag.String("log.format", "logfmt", "output format of log messages, one of logfmt and json")
 )
 
 func main() {
-	flag.Parse()
-
-	// Check the arguments
-	if len(*adminAK) == 0 || len(*adminSK) == 0 {
-		fmt.Printf("invalid admin AK/SK for the radosgw service")
-		return
+	// The report command generates a chargeback report and exits instead of serving
+	args, command := os.Args[1:], run
+	if len(args) != 0 && args[0] == "report" {
+		args, command = args[1:], runReport
 	}
-	tcpAddr, err := net.ResolveTCPAddr("tcp", *listenAddr)
+	flag.CommandLine.Parse(args)
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
 	if err != nil {
-		fmt.Printf("invalid listen address of TCP: %v", err)
-		return
+		fmt.Fprintf(os.Stderr, "radosgw exporter occurs error: %v\n", err)
+		os.Exit(1)
 	}
+	if err := command(logger); err != nil {
+		logger.Error("radosgw exporter occurs error", "err", err)
+		os.Exit(1)
+	}
+}
 
-	// Register the handlers
-	collector, err := NewRadosgwCollector(*endpoint, *adminAK, *adminSK)
+// run - start the exporter and block until it is stopped by SIGTERM or SIGINT
+func run(logger *slog.Logger) error {
+	// Check the arguments, the admin AK/SK may be omitted if given by the config file
+	credentials, err := flagCredentials()
 	if err != nil {
-		fmt.Printf("create radosgw collector failed: %v", err)
-		return
+		return fmt.Errorf("invalid admin AK/SK for the radosgw service: %v", err)
+	}
+	if credentials == nil && len(*configFile) == 0 {
+		return fmt.Errorf("invalid admin AK/SK for the radosgw service")
+	}
+	switch *userEmail {
+	case emailNone, emailHash, emailPlain:
+	default:
+		return fmt.Errorf("invalid -collector.user.email %s, should be one of none, hash and plain", *userEmail)
+	}
+	switch *usageOutput {
+	case usageOutputBuckets, usageOutputUsers, usageOutputBoth:
+	default:
+		return fmt.Errorf("invalid -collector.usage.output %s, should be one of buckets, users and both", *usageOutput)
 	}
-	err = prometheus.Register(collector)
+	if *probeCacheSize <= 0 || *probeCacheTTL <= 0 {
+		return fmt.Errorf("invalid -probe.cache.size or -probe.cache.ttl, should be positive")
+	}
+	if *costInterval < 0 {
+		return fmt.Errorf("invalid -collector.cost.interval, should not be negative")
+	}
+	switch *growthMethod {
+	case growthMethodLinear, growthMethodHolt:
+	default:
+		return fmt.Errorf("invalid -collector.growth.method %s, should be one of linear and holt", *growthMethod)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", *listenAddr)
 	if err != nil {
-		fmt.Printf("register the collector failed: %v", err)
-		return
+		return fmt.Errorf("invalid listen address of TCP: %v", err)
+	}
+	var web *WebConfig
+	if len(*webConfig) != 0 {
+		if web, err = LoadWebConfig(*webConfig); err != nil {
+			return fmt.Errorf("load the web config file failed: %v", err)
+		}
 	}
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+
+	// Load the config file and reload it on SIGHUP
+	manager := newClusterManager(*configFile, *endpoint, credentials, *pollInterval, logger)
+	if err := manager.Reload(); err != nil {
+		return fmt.Errorf("load the config file failed: %v", err)
+	}
+	defer manager.Stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			manager.Reload()
+		}
+	}()
+
+	// Register the handlers, the health endpoints are not protected by basic auth
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, promhttp.HandlerFor(
+		prometheus.Gatherers{prometheus.DefaultGatherer, manager}, promhttp.HandlerOpts{}))
+	mux.Handle("/probe", &prober{manager})
+	mux.Handle("/report", &reporter{manager})
+	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "only POST or PUT is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := manager.Reload(); err != nil {
+			http.Error(w, fmt.Sprintf("reload the config file failed: %v", err),
+				http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(200)
 		w.Write([]byte(`
@@ -61,13 +149,125 @@ func main() {
                 </body>
             </html>`))
 	})
+	var handler http.Handler = mux
+	if web != nil && len(web.BasicAuthUsers) != 0 {
+		handler = newBasicAuthHandler(web.BasicAuthUsers, mux)
+	}
+	root := http.NewServeMux()
+	root.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Healthy\n"))
+	})
+	root.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !manager.Ready() {
+			http.Error(w, "Not ready, the last check of radosgw failed or has not completed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("Ready\n"))
+	})
+	root.Handle("/", handler)
 
-	// Listen and serve
+	// Listen and serve with the TLS given by the web config file
 	tcpListener, err := net.ListenTCP("tcp", tcpAddr)
-	err = http.Serve(customTCPListener{tcpListener}, nil)
 	if err != nil {
-		fmt.Printf("radosgw exporter occurs error: %v", err)
+		return fmt.Errorf("listen on %s failed: %v", *listenAddr, err)
+	}
+	var listener net.Listener = customTCPListener{tcpListener}
+	if web != nil && web.TLSConfig != nil {
+		reloader, err := newCertReloader(web.TLSConfig, logger)
+		if err != nil {
+			tcpListener.Close()
+			return fmt.Errorf("load the certificates failed: %v", err)
+		}
+		listener = tls.NewListener(listener, reloader.tlsConfig())
+	}
+	server := &http.Server{
+		Handler:  root,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(listener) }()
+	logger.Info("radosgw exporter started", "addr", *listenAddr, "tls", web != nil && web.TLSConfig != nil)
+
+	// Stop accepting new connections and drain the in-flight scrapes on SIGTERM or SIGINT
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-term:
+		logger.Info("shutting down the radosgw exporter", "signal", sig.String())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutdown failed: %v", err)
+	}
+	return nil
+}
+
+// flagCredentials - get the credentials provider given by the command line flags, the
+// environment variables AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used if no flag
+// is given, and nil is returned if they are not set either.
+func flagCredentials() (radosgw.CredentialsProvider, error) {
+	var result radosgw.CredentialsProvider
+	switch {
+	case len(*adminAK) != 0 || len(*adminSK) != 0:
+		result = radosgw.NewStaticProvider(*adminAK, *adminSK)
+	case len(*adminAKFile) != 0 || len(*adminSKFile) != 0:
+		result = radosgw.NewFileProvider(*adminAKFile, *adminSKFile)
+	case len(*sharedFile) != 0 || len(*profile) != 0:
+		result = radosgw.NewSharedCredentialsProvider(*sharedFile, *profile)
+	default:
+		env := radosgw.NewEnvProvider("", "")
+		if _, err := env.Retrieve(); err != nil {
+			return nil, nil
+		}
+		return env, nil
+	}
+	if _, err := result.Retrieve(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// prober serves the /probe endpoint to collect metrics of the radosgw service given by
+// the target parameter with the credentials of the auth module given by the module
+// parameter.
+type prober struct {
+	manager *clusterManager
+}
+
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if len(target) == 0 {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	moduleName := r.URL.Query().Get("module")
+	if len(moduleName) == 0 {
+		moduleName = defaultProbeModule
+	}
+
+	collector, err := p.manager.probeCollector(moduleName, target)
+	if err == errUnknownModule {
+		http.Error(w, fmt.Sprintf("unknown auth module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	if err == errTargetNotAllowed {
+		http.Error(w, fmt.Sprintf("target %q is not allowed by the auth module %q", target, moduleName),
+			http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }
 
 type customTCPListener struct {
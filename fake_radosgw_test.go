@@ -0,0 +1,343 @@
+// fake_radosgw_test.go - implement the fake admin op API of radosgw for the tests
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const fakeUsageTimeFormat = "2006-01-02 15:04:05"
+
+// fakeUsage is an entry of the usage log of the fake radosgw.
+type fakeUsage struct {
+	user     string
+	bucket   string
+	epoch    time.Time
+	category string
+	value    usageValue
+}
+
+// fakeRadosgw serves the users, the buckets and the usage log kept in memory, and records
+// the requests. The handlers override the default ones by the method and path.
+type fakeRadosgw struct {
+	server *httptest.Server
+	client *radosgw.Client
+
+	mtx      sync.Mutex
+	users    map[string]*radosgw.UserType
+	buckets  []*radosgw.BucketStatsType
+	usage    []fakeUsage
+	handlers map[string]http.HandlerFunc
+	requests []*http.Request
+}
+
+func newFakeRadosgw(t *testing.T) *fakeRadosgw {
+	f := &fakeRadosgw{
+		users:    make(map[string]*radosgw.UserType),
+		handlers: make(map[string]http.HandlerFunc),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	var err error
+	if f.client, err = radosgw.NewClient(f.server.URL, "ak", "sk"); err != nil {
+		t.Fatalf("create the client failed: %v", err)
+	}
+	return f
+}
+
+// handle - override the handler of the method and path, such as "GET /admin/user"
+func (f *fakeRadosgw) handle(route string, handler http.HandlerFunc) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.handlers[route] = handler
+}
+
+func (f *fakeRadosgw) addUser(user *radosgw.UserType) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.users[user.UserID] = user
+}
+
+func (f *fakeRadosgw) deleteUser(uid string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	delete(f.users, uid)
+}
+
+func (f *fakeRadosgw) user(uid string) *radosgw.UserType {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.users[uid]
+}
+
+// setBuckets - replace the stats of the buckets
+func (f *fakeRadosgw) setBuckets(buckets ...*radosgw.BucketStatsType) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.buckets = buckets
+}
+
+// setUsage - replace the usage log
+func (f *fakeRadosgw) setUsage(usage ...fakeUsage) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.usage = usage
+}
+
+// recorded - get the requests of the method and path
+func (f *fakeRadosgw) recorded(route string) []*http.Request {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var result []*http.Request
+	for _, r := range f.requests {
+		if r.Method+" "+r.URL.Path == route {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func (f *fakeRadosgw) serve(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + r.URL.Path
+	f.mtx.Lock()
+	f.requests = append(f.requests, r)
+	handler, ok := f.handlers[route]
+	f.mtx.Unlock()
+	if ok {
+		handler(w, r)
+		return
+	}
+	switch route {
+	case "GET /admin/metadata/user":
+		f.listUsers(w, r)
+	case "GET /admin/user":
+		f.getUser(w, r)
+	case "POST /admin/user":
+		f.updateUser(w, r)
+	case "PUT /admin/user":
+		f.setQuota(w, r)
+	case "GET /admin/bucket":
+		f.getBucket(w, r)
+	case "GET /admin/usage":
+		f.getUsage(w, r)
+	case "DELETE /admin/usage":
+		f.deleteUsage(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeRadosgw) listUsers(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	uids := make([]string, 0, len(f.users))
+	for uid := range f.users {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	writeFakeJSON(w, uids)
+}
+
+func (f *fakeRadosgw) getUser(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	user, ok := f.users[r.URL.Query().Get("uid")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeFakeJSON(w, map[string]string{"Code": "NoSuchUser"})
+		return
+	}
+	writeFakeJSON(w, user)
+}
+
+func (f *fakeRadosgw) updateUser(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	query := r.URL.Query()
+	user, ok := f.users[query.Get("uid")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if _, ok := query["display-name"]; ok {
+		user.DisplayName = query.Get("display-name")
+	}
+	switch query.Get("suspended") {
+	case "1", "true":
+		user.Suspended = 1
+	case "0", "false":
+		user.Suspended = 0
+	}
+	writeFakeJSON(w, user)
+}
+
+func (f *fakeRadosgw) setQuota(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	query := r.URL.Query()
+	user, ok := f.users[query.Get("uid")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	quota := radosgw.QuotaType{MaxObjects: -1, MaxSize: -1, Enabled: query.Get("enabled") == "true"}
+	if v := query.Get("max-objects"); len(v) != 0 {
+		quota.MaxObjects, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v := query.Get("max-size-kb"); len(v) != 0 {
+		quota.MaxSize, _ = strconv.ParseInt(v, 10, 64)
+		quota.MaxSize *= 1024
+	}
+	if query.Get("quota-type") == "user" {
+		user.UserQuota = quota
+	} else {
+		user.BucketQuota = quota
+	}
+	writeFakeJSON(w, user)
+}
+
+// getBucket - serve the stats of the buckets owned by the uid, or of all buckets
+func (f *fakeRadosgw) getBucket(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	uid := r.URL.Query().Get("uid")
+	if len(uid) != 0 {
+		if _, ok := f.users[uid]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	result := make([]*radosgw.BucketStatsType, 0, len(f.buckets))
+	for _, b := range f.buckets {
+		if len(uid) == 0 || b.Owner == uid {
+			result = append(result, b)
+		}
+	}
+	writeFakeJSON(w, result)
+}
+
+// getUsage - serve the usage log entries and the summary in the time range
+func (f *fakeRadosgw) getUsage(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	query := r.URL.Query()
+	var start, end time.Time
+	if v := query.Get("start"); len(v) != 0 {
+		start, _ = time.Parse(fakeUsageTimeFormat, v)
+	}
+	if v := query.Get("end"); len(v) != 0 {
+		end, _ = time.Parse(fakeUsageTimeFormat, v)
+	}
+
+	type category struct {
+		Category      string `json:"category"`
+		BytesSent     int64  `json:"bytes_sent"`
+		BytesReceived int64  `json:"bytes_received"`
+		Ops           int64  `json:"ops"`
+		SuccessfulOps int64  `json:"successful_ops"`
+	}
+	type bucket struct {
+		Bucket     string     `json:"bucket"`
+		Epoch      int64      `json:"epoch"`
+		Categories []category `json:"categories"`
+	}
+	type entry struct {
+		User    string   `json:"user"`
+		Buckets []bucket `json:"buckets"`
+	}
+	type summary struct {
+		User       string     `json:"user"`
+		Categories []category `json:"categories"`
+	}
+	result := struct {
+		Entries []entry   `json:"entries"`
+		Summary []summary `json:"summary"`
+	}{[]entry{}, []summary{}}
+	entries := make(map[string]*entry)
+	summaries := make(map[string]*summary)
+	for _, u := range f.usage {
+		if u.epoch.Before(start) || (!end.IsZero() && !u.epoch.Before(end)) {
+			continue
+		}
+		c := category{u.category, u.value.BytesSent, u.value.BytesReceived, u.value.Ops,
+			u.value.SuccessfulOps}
+		if _, ok := entries[u.user]; !ok {
+			entries[u.user] = &entry{User: u.user}
+			summaries[u.user] = &summary{User: u.user}
+		}
+		e := entries[u.user]
+		e.Buckets = append(e.Buckets, bucket{u.bucket, u.epoch.Unix(), []category{c}})
+		s := summaries[u.user]
+		found := false
+		for i := range s.Categories {
+			if s.Categories[i].Category == c.Category {
+				s.Categories[i].BytesSent += c.BytesSent
+				s.Categories[i].BytesReceived += c.BytesReceived
+				s.Categories[i].Ops += c.Ops
+				s.Categories[i].SuccessfulOps += c.SuccessfulOps
+				found = true
+			}
+		}
+		if !found {
+			s.Categories = append(s.Categories, c)
+		}
+	}
+	for user := range entries {
+		if query.Get("show-entries") == "true" {
+			result.Entries = append(result.Entries, *entries[user])
+		}
+		if query.Get("show-summary") == "true" {
+			result.Summary = append(result.Summary, *summaries[user])
+		}
+	}
+	writeFakeJSON(w, result)
+}
+
+// deleteUsage - delete the usage log entries of the uid, or of all users, in the time range
+func (f *fakeRadosgw) deleteUsage(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	query := r.URL.Query()
+	var start, end time.Time
+	if v := query.Get("start"); len(v) != 0 {
+		start, _ = time.Parse(fakeUsageTimeFormat, v)
+	}
+	if v := query.Get("end"); len(v) != 0 {
+		end, _ = time.Parse(fakeUsageTimeFormat, v)
+	}
+	uid := query.Get("uid")
+	if len(uid) == 0 && query.Get("remove-all") != "true" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	kept := make([]fakeUsage, 0, len(f.usage))
+	for _, u := range f.usage {
+		if (len(uid) == 0 || u.user == uid) && !u.epoch.Before(start) &&
+			(end.IsZero() || u.epoch.Before(end)) {
+			continue
+		}
+		kept = append(kept, u)
+	}
+	f.usage = kept
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// testLogger - get the logger discarding the logs of the tests
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
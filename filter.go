@@ -0,0 +1,120 @@
+// filter.go - implement the label filters of the exported metrics
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+// labelFilter matches a label value by the anchored include and exclude expressions.
+type labelFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func newLabelFilter(name string, cfg FilterConfig) (labelFilter, error) {
+	result := labelFilter{}
+	var err error
+	if len(cfg.Include) != 0 {
+		if result.include, err = regexp.Compile("^(?:" + cfg.Include + ")$"); err != nil {
+			return result, fmt.Errorf("invalid %s include filter: %v", name, err)
+		}
+	}
+	if len(cfg.Exclude) != 0 {
+		if result.exclude, err = regexp.Compile("^(?:" + cfg.Exclude + ")$"); err != nil {
+			return result, fmt.Errorf("invalid %s exclude filter: %v", name, err)
+		}
+	}
+	return result, nil
+}
+
+func (f labelFilter) match(value string) bool {
+	if f.include != nil && !f.include.MatchString(value) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(value)
+}
+
+// labelFilters selects the users, buckets and usage categories exported by the
+// sub-collectors, a nil value matches everything.
+type labelFilters struct {
+	users      labelFilter
+	buckets    labelFilter
+	categories labelFilter
+}
+
+func newLabelFilters(cfg *FiltersConfig) (*labelFilters, error) {
+	users, err := newLabelFilter("users", cfg.Users)
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := newLabelFilter("buckets", cfg.Buckets)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := newLabelFilter("categories", cfg.Categories)
+	if err != nil {
+		return nil, err
+	}
+	return &labelFilters{users: users, buckets: buckets, categories: categories}, nil
+}
+
+func (f *labelFilters) matchUser(user string) bool {
+	return f == nil || f.users.match(user)
+}
+
+func (f *labelFilters) matchBucket(user, bucket string) bool {
+	return f == nil || (f.users.match(user) && f.buckets.match(bucket))
+}
+
+func (f *labelFilters) matchCategory(category string) bool {
+	return f == nil || f.categories.match(category)
+}
+
+// aggregation is the only one of the user, bucket and category labels kept by summing up
+// the series, the other labels are set to empty which prometheus treats as absent. The
+// tenant label is kept along with the user and bucket ones. The empty aggregation keeps
+// all labels.
+type aggregation string
+
+const (
+	aggregateNone     aggregation = ""
+	aggregateUser     aggregation = "user"
+	aggregateBucket   aggregation = "bucket"
+	aggregateCategory aggregation = "category"
+)
+
+func (a aggregation) valid() bool {
+	switch a {
+	case aggregateNone, aggregateUser, aggregateBucket, aggregateCategory:
+		return true
+	}
+	return false
+}
+
+// labels - get the label values kept by the aggregation
+func (a aggregation) labels(tenant, user, bucket, category string) (string, string, string, string) {
+	switch a {
+	case aggregateUser:
+		return tenant, user, "", ""
+	case aggregateBucket:
+		return tenant, "", bucket, ""
+	case aggregateCategory:
+		return "", "", "", category
+	}
+	return tenant, user, bucket, category
+}
+
+// tenantLabels - split the qualified user id and bucket name into the tenant, user and
+// bucket labels, the tenant of the bucket is used if the user is not in a tenant
+func tenantLabels(uid, bucket string) (string, string, string) {
+	tenant, user := radosgw.SplitUserId(uid)
+	bucketTenant, bucket := radosgw.SplitBucketName(bucket)
+	if len(tenant) == 0 {
+		tenant = bucketTenant
+	}
+	return tenant, user, bucket
+}
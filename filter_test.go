@@ -0,0 +1,83 @@
+// filter_test.go - test the label filters and the aggregation of the exported metrics
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLabelFilters(t *testing.T) {
+	filters, err := newLabelFilters(&FiltersConfig{
+		Users:      FilterConfig{Include: "alice|bob"},
+		Buckets:    FilterConfig{Exclude: "tmp-.*"},
+		Categories: FilterConfig{Include: "get_obj|put_obj"},
+	})
+	if err != nil {
+		t.Fatalf("create the filters failed: %v", err)
+	}
+	cases := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"included user", filters.matchUser("alice"), true},
+		// The expressions match the whole label value
+		{"user of the prefix", filters.matchUser("alice2"), false},
+		{"bucket of the included user", filters.matchBucket("bob", "b1"), true},
+		{"excluded bucket", filters.matchBucket("bob", "tmp-1"), false},
+		{"bucket of another user", filters.matchBucket("carol", "b1"), false},
+		{"included category", filters.matchCategory("put_obj"), true},
+		{"another category", filters.matchCategory("list_bucket"), false},
+		{"nil filters", (*labelFilters)(nil).matchBucket("carol", "tmp-1"), true},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("%s: got matched %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if _, err := newLabelFilters(&FiltersConfig{Buckets: FilterConfig{Include: "("}}); err == nil {
+		t.Errorf("got no error of the invalid expression")
+	}
+}
+
+func TestAggregationLabels(t *testing.T) {
+	cases := []struct {
+		aggregation aggregation
+		want        string
+	}{
+		{aggregateNone, "[acme alice b1 get_obj]"},
+		{aggregateUser, "[acme alice  ]"},
+		{aggregateBucket, "[acme  b1 ]"},
+		{aggregateCategory, "[   get_obj]"},
+	}
+	for _, c := range cases {
+		tenant, user, bucket, category := c.aggregation.labels("acme", "alice", "b1", "get_obj")
+		if got := fmt.Sprint([]string{tenant, user, bucket, category}); got != c.want {
+			t.Errorf("got the labels %s of the %q aggregation, want %s", got, c.aggregation, c.want)
+		}
+	}
+	if aggregation("tenant").valid() {
+		t.Errorf("got the unknown aggregation valid")
+	}
+}
+
+func TestTenantLabels(t *testing.T) {
+	cases := []struct {
+		uid, bucket string
+		want        string
+	}{
+		{"alice", "b1", "[ alice b1]"},
+		{"acme$alice", "b1", "[acme alice b1]"},
+		{"acme$alice", "acme/b1", "[acme alice b1]"},
+		// The bucket of a tenant can be linked to a user out of it
+		{"alice", "acme/b1", "[acme alice b1]"},
+		{"", "", "[  ]"},
+	}
+	for _, c := range cases {
+		tenant, user, bucket := tenantLabels(c.uid, c.bucket)
+		if got := fmt.Sprint([]string{tenant, user, bucket}); got != c.want {
+			t.Errorf("got the labels %s of %q and %q, want %s", got, c.uid, c.bucket, c.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// growth_state.go - implement the rolling window of bucket size samples for forecasting
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	growthMethodLinear = "linear"
+	growthMethodHolt   = "holt"
+
+	// holtAlpha and holtBeta are the smoothing factors of the level and trend of the Holt
+	// method, the trend follows the recent samples more slowly than the level.
+	holtAlpha = 0.5
+	holtBeta  = 0.3
+)
+
+// growthKey identifies a bucket by the owner and the bucket name qualified by the tenant.
+type growthKey struct {
+	Owner  string `json:"owner"`
+	Bucket string `json:"bucket"`
+}
+
+// growthSample is the size of a bucket at a unix timestamp.
+type growthSample struct {
+	Time int64 `json:"time"`
+	Size int64 `json:"size"`
+}
+
+// growthRecord is the samples of a bucket in the state file.
+type growthRecord struct {
+	growthKey
+	Samples []growthSample `json:"samples"`
+}
+
+// growthStateFile is the content of the persisted state file.
+type growthStateFile struct {
+	Endpoint string         `json:"endpoint"`
+	Buckets  []growthRecord `json:"buckets"`
+}
+
+// growthState keeps the size samples of each bucket in the window, a sample is only taken
+// once per sample interval however often the collector runs.
+type growthState struct {
+	endpoint string
+	file     stateFile
+
+	mtx     sync.Mutex
+	loaded  bool
+	samples map[growthKey][]growthSample
+}
+
+// growthStateFor - get the shared state of the radosgw endpoint
+func growthStateFor(endpoint string) *growthState {
+	return endpointState("growth", endpoint, func() interface{} {
+		return newGrowthState(endpoint, *growthStateDir)
+	}).(*growthState)
+}
+
+// newGrowthState - create the state of the radosgw endpoint persisted into the directory
+func newGrowthState(endpoint, dir string) *growthState {
+	return &growthState{
+		endpoint: endpoint,
+		file:     newStateFile("growth", endpoint, dir),
+		samples:  make(map[growthKey][]growthSample),
+	}
+}
+
+// update - add the sizes of the buckets as new samples unless the last ones are taken
+// within the interval, drop the samples out of the window, and get the growth rate of
+// each bucket of the sizes in bytes per second by the method. The samples of the buckets
+// not given are only dropped by age, as they may be deleted or filtered out by another
+// collector sharing the state.
+func (s *growthState) update(sizes map[growthKey]int64, now time.Time, interval, window time.Duration,
+	method string) (map[growthKey]float64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return nil, &scrapeError{"state_file", err}
+		}
+		s.loaded = true
+	}
+
+	changed := false
+	for key, size := range sizes {
+		samples := s.samples[key]
+		if n := len(samples); n == 0 || now.Sub(time.Unix(samples[n-1].Time, 0)) >= interval {
+			s.samples[key] = append(samples, growthSample{now.Unix(), size})
+			changed = true
+		}
+	}
+	oldest := now.Add(-window).Unix()
+	for key, samples := range s.samples {
+		i := 0
+		for i < len(samples) && samples[i].Time < oldest {
+			i++
+		}
+		switch {
+		case i == len(samples):
+			delete(s.samples, key)
+			changed = true
+		case i > 0:
+			s.samples[key] = samples[i:]
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.save(); err != nil {
+			return nil, &scrapeError{"state_file", err}
+		}
+	}
+
+	result := make(map[growthKey]float64, len(sizes))
+	for key := range sizes {
+		samples := s.samples[key]
+		if len(samples) < 2 {
+			continue
+		}
+		if method == growthMethodHolt {
+			result[key] = holtTrend(samples)
+		} else {
+			result[key] = linearTrend(samples)
+		}
+	}
+	return result, nil
+}
+
+// averageSizes - get the average size of each bucket in the period weighted by time. A
+// sample holds until the next one of the bucket, or for the sample interval if it is the
+// last one, so the deleted buckets are billed until they are no longer sampled. The
+// samples should cover the whole period.
+func (s *growthState) averageSizes(start, end time.Time, interval time.Duration) (map[growthKey]int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		s.loaded = true
+	}
+	if len(s.samples) == 0 {
+		return nil, fmt.Errorf("no bucket size samples, which are taken by the growth collector")
+	}
+	first, last := int64(0), int64(0)
+	for _, samples := range s.samples {
+		if n := len(samples); n != 0 {
+			if first == 0 || samples[0].Time < first {
+				first = samples[0].Time
+			}
+			if samples[n-1].Time > last {
+				last = samples[n-1].Time
+			}
+		}
+	}
+	seconds := int64(interval / time.Second)
+	if first > start.Unix()+seconds || last < end.Unix()-seconds {
+		return nil, fmt.Errorf("the bucket size samples from %s to %s do not cover the period, "+
+			"-collector.growth.window may be too short",
+			time.Unix(first, 0).UTC().Format(time.RFC3339), time.Unix(last, 0).UTC().Format(time.RFC3339))
+	}
+
+	result := make(map[growthKey]int64, len(s.samples))
+	for key, samples := range s.samples {
+		sum := 0.0
+		for i, sample := range samples {
+			from, to := sample.Time, sample.Time+seconds
+			if i+1 < len(samples) {
+				to = samples[i+1].Time
+			}
+			if from < start.Unix() {
+				from = start.Unix()
+			}
+			if to > end.Unix() {
+				to = end.Unix()
+			}
+			if to > from {
+				sum += float64(sample.Size) * float64(to-from)
+			}
+		}
+		if sum > 0 {
+			result[key] = int64(sum / float64(end.Unix()-start.Unix()))
+		}
+	}
+	return result, nil
+}
+
+// linearTrend - get the slope of the least squares line of the samples
+func linearTrend(samples []growthSample) float64 {
+	n := float64(len(samples))
+	base := samples[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x, y := float64(sample.Time-base), float64(sample.Size)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// holtTrend - get the trend of the samples by the Holt double exponential smoothing, the
+// trend is per second as the samples may not be evenly spaced
+func holtTrend(samples []growthSample) float64 {
+	level := float64(samples[0].Size)
+	trend := 0.0
+	for i := 1; i < len(samples); i++ {
+		dt := float64(samples[i].Time - samples[i-1].Time)
+		if dt <= 0 {
+			continue
+		}
+		if i == 1 {
+			trend = (float64(samples[1].Size) - level) / dt
+		}
+		last := level
+		level = holtAlpha*float64(samples[i].Size) + (1-holtAlpha)*(level+trend*dt)
+		trend = holtBeta*(level-last)/dt + (1-holtBeta)*trend
+	}
+	return trend
+}
+
+// load - restore the samples from the state file if it exists
+func (s *growthState) load() error {
+	file := &growthStateFile{}
+	if ok, err := s.file.read(file); !ok {
+		return err
+	}
+	for _, r := range file.Buckets {
+		s.samples[r.growthKey] = r.Samples
+	}
+	return nil
+}
+
+// save - write the samples into the state file
+func (s *growthState) save() error {
+	if !s.file.persisted() {
+		return nil
+	}
+	file := &growthStateFile{
+		Endpoint: s.endpoint,
+		Buckets:  make([]growthRecord, 0, len(s.samples)),
+	}
+	for key, samples := range s.samples {
+		file.Buckets = append(file.Buckets, growthRecord{key, samples})
+	}
+	sort.Slice(file.Buckets, func(i, j int) bool {
+		if file.Buckets[i].Owner != file.Buckets[j].Owner {
+			return file.Buckets[i].Owner < file.Buckets[j].Owner
+		}
+		return file.Buckets[i].Bucket < file.Buckets[j].Bucket
+	})
+	return s.file.write(file)
+}
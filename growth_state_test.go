@@ -0,0 +1,130 @@
+// growth_state_test.go - test the rolling window of bucket size samples
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+var (
+	testGrowthB1 = growthKey{"alice", "b1"}
+	testGrowthB2 = growthKey{"alice", "b2"}
+)
+
+func updateGrowthState(t *testing.T, s *growthState, sizes map[growthKey]int64,
+	now time.Time) map[growthKey]float64 {
+	t.Helper()
+	rates, err := s.update(sizes, now, time.Hour, 24*time.Hour, growthMethodLinear)
+	if err != nil {
+		t.Fatalf("update the growth state failed: %v", err)
+	}
+	return rates
+}
+
+func TestGrowthStateSamples(t *testing.T) {
+	s := newGrowthState("http://rgw", "")
+	start := testHour(0, 0)
+	updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 0}, start)
+	rates := updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 100}, start.Add(30*time.Minute))
+	if len(s.samples[testGrowthB1]) != 1 {
+		t.Errorf("got %d samples within the interval, want 1", len(s.samples[testGrowthB1]))
+	}
+	if _, ok := rates[testGrowthB1]; ok {
+		t.Errorf("got the rate of a single sample")
+	}
+	rates = updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 3600}, start.Add(time.Hour))
+	if rate := rates[testGrowthB1]; math.Abs(rate-1) > 1e-9 {
+		t.Errorf("got rate %v, want 1 byte per second", rate)
+	}
+
+	// The samples out of the window are dropped
+	rates = updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 7200}, start.Add(25*time.Hour))
+	if n := len(s.samples[testGrowthB1]); n != 2 {
+		t.Errorf("got %d samples in the window, want 2", n)
+	}
+	if rate := rates[testGrowthB1]; math.Abs(rate-1.0/24) > 1e-9 {
+		t.Errorf("got rate %v, want 1/24 byte per second", rate)
+	}
+}
+
+func TestGrowthStateShared(t *testing.T) {
+	s := newGrowthState("http://rgw", "")
+	start := testHour(0, 0)
+
+	// The collectors with different filters share the state without erasing the samples
+	for i := 0; i < 3; i++ {
+		now := start.Add(time.Duration(i) * time.Hour)
+		updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: int64(i) * 3600}, now)
+		rates := updateGrowthState(t, s, map[growthKey]int64{testGrowthB2: int64(i) * 7200}, now)
+		if _, ok := rates[testGrowthB1]; ok {
+			t.Errorf("got the rate of the bucket not given")
+		}
+	}
+	if n := len(s.samples[testGrowthB1]); n != 3 {
+		t.Errorf("got %d samples of b1, want 3", n)
+	}
+	if n := len(s.samples[testGrowthB2]); n != 3 {
+		t.Errorf("got %d samples of b2, want 3", n)
+	}
+
+	// The samples of the deleted bucket are kept until out of the window
+	updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 0}, start.Add(12*time.Hour))
+	if n := len(s.samples[testGrowthB2]); n != 3 {
+		t.Errorf("got %d samples of the deleted bucket, want 3", n)
+	}
+	updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 0}, start.Add(27*time.Hour))
+	if _, ok := s.samples[testGrowthB2]; ok {
+		t.Errorf("got the samples of the deleted bucket out of the window")
+	}
+}
+
+func TestGrowthStateReload(t *testing.T) {
+	dir := t.TempDir()
+	s := newGrowthState("http://rgw", dir)
+	start := testHour(0, 0)
+	updateGrowthState(t, s, map[growthKey]int64{testGrowthB1: 0}, start)
+
+	reloaded := newGrowthState("http://rgw", dir)
+	rates := updateGrowthState(t, reloaded, map[growthKey]int64{testGrowthB1: 3600}, start.Add(time.Hour))
+	if rate := rates[testGrowthB1]; math.Abs(rate-1) > 1e-9 {
+		t.Errorf("got rate %v after reloaded, want 1 byte per second", rate)
+	}
+}
+
+func TestGrowthCollectorDeletedOwner(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.addUser(&radosgw.UserType{UserID: "alice",
+		UserQuota: radosgw.QuotaType{Enabled: true, MaxSize: 7200, MaxObjects: -1}})
+	f.setBuckets(testBucketStats("alice", "b1", 3600), testBucketStats("ghost", "b2", 3600))
+	state := newGrowthState(f.server.URL, "")
+	state.loaded = true
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+	state.samples[growthKey{"alice", "b1"}] = []growthSample{{hourAgo, 0}}
+	state.samples[growthKey{"ghost", "b2"}] = []growthSample{{hourAgo, 0}}
+	collector := &growthCollector{
+		window:    24 * time.Hour,
+		interval:  time.Minute,
+		method:    growthMethodLinear,
+		ephemeral: true,
+		state:     state,
+	}
+
+	// The owner deleted after the buckets are listed is skipped
+	metrics, err := collector.Update(f.client)
+	if err != nil {
+		t.Fatalf("update the growth failed: %v", err)
+	}
+	exhaustion := 0
+	for _, m := range metrics {
+		if m.Desc() == userQuotaExhaustionDesc {
+			exhaustion++
+		}
+	}
+	if exhaustion != 1 {
+		t.Errorf("got %d quota exhaustion metrics, want 1 of alice", exhaustion)
+	}
+}
@@ -0,0 +1,453 @@
+// inventory.go - implement the change events of the users and buckets
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const (
+	defaultInventoryInterval = 5 * time.Minute
+	inventoryWebhookTimeout  = 10 * time.Second
+
+	eventBucketCreated = "bucket_created"
+	eventBucketDeleted = "bucket_deleted"
+	eventOwnerChanged  = "owner_changed"
+	eventUserSuspended = "user_suspended"
+	eventUserDeleted   = "user_deleted"
+	eventQuotaChanged  = "quota_changed"
+	eventKeyAdded      = "key_added"
+)
+
+var inventoryStateDir = flag.String("inventory.state.dir", "",
+	"directory to persist the last inventory snapshot across restarts, empty means only in memory")
+
+// inventoryEvent is a change of a user or bucket found between two inventory snapshots,
+// the old and new values are only given for the changes of the owner and quota.
+type inventoryEvent struct {
+	Time      time.Time   `json:"time"`
+	Type      string      `json:"type"`
+	Cluster   string      `json:"cluster,omitempty"`
+	User      string      `json:"user,omitempty"`
+	Bucket    string      `json:"bucket,omitempty"`
+	AccessKey string      `json:"access_key,omitempty"`
+	Old       interface{} `json:"old,omitempty"`
+	New       interface{} `json:"new,omitempty"`
+}
+
+// inventoryBucket is a bucket in the inventory snapshot.
+type inventoryBucket struct {
+	owner string
+	quota radosgw.QuotaType
+}
+
+// inventoryUser is a user in the inventory snapshot.
+type inventoryUser struct {
+	suspended bool
+	quota     radosgw.QuotaType
+	keys      map[string]bool
+}
+
+// inventorySnapshot has the buckets by the names qualified by the tenant and the users by
+// the user ids.
+type inventorySnapshot struct {
+	buckets map[string]inventoryBucket
+	users   map[string]inventoryUser
+}
+
+// inventoryBucketRecord is a bucket of the state file.
+type inventoryBucketRecord struct {
+	Name  string            `json:"name"`
+	Owner string            `json:"owner"`
+	Quota radosgw.QuotaType `json:"quota"`
+}
+
+// inventoryUserRecord is a user of the state file.
+type inventoryUserRecord struct {
+	ID        string            `json:"id"`
+	Suspended bool              `json:"suspended,omitempty"`
+	Quota     radosgw.QuotaType `json:"quota"`
+	Keys      []string          `json:"keys,omitempty"`
+}
+
+// inventoryStateFile is the content of the persisted state file.
+type inventoryStateFile struct {
+	Endpoint string                  `json:"endpoint"`
+	Buckets  []inventoryBucketRecord `json:"buckets"`
+	Users    []inventoryUserRecord   `json:"users"`
+}
+
+// inventoryState has the last snapshot of the radosgw endpoint, which is taken over by the
+// watcher rebuilt by the configuration reload. It is persisted so the changes while the
+// exporter is not running are sent after it restarts.
+type inventoryState struct {
+	endpoint string
+	file     stateFile
+
+	// mtx is held by the watcher comparing the inventory of the endpoint.
+	mtx    sync.Mutex
+	loaded bool
+	last   *inventorySnapshot
+}
+
+// inventoryStateFor - get the shared state of the radosgw endpoint
+func inventoryStateFor(endpoint string) *inventoryState {
+	return endpointState("inventory", endpoint, func() interface{} {
+		return newInventoryState(endpoint, *inventoryStateDir)
+	}).(*inventoryState)
+}
+
+// newInventoryState - create the state of the radosgw endpoint persisted into the directory
+func newInventoryState(endpoint, dir string) *inventoryState {
+	return &inventoryState{endpoint: endpoint, file: newStateFile("inventory", endpoint, dir)}
+}
+
+// load - restore the last snapshot from the state file if it exists
+func (s *inventoryState) load() error {
+	file := &inventoryStateFile{}
+	if ok, err := s.file.read(file); !ok {
+		return err
+	}
+	s.last = &inventorySnapshot{
+		buckets: make(map[string]inventoryBucket, len(file.Buckets)),
+		users:   make(map[string]inventoryUser, len(file.Users)),
+	}
+	for _, r := range file.Buckets {
+		s.last.buckets[r.Name] = inventoryBucket{owner: r.Owner, quota: r.Quota}
+	}
+	for _, r := range file.Users {
+		user := inventoryUser{suspended: r.Suspended, quota: r.Quota, keys: make(map[string]bool, len(r.Keys))}
+		for _, key := range r.Keys {
+			user.keys[key] = true
+		}
+		s.last.users[r.ID] = user
+	}
+	return nil
+}
+
+// save - write the last snapshot into the state file
+func (s *inventoryState) save() error {
+	if !s.file.persisted() {
+		return nil
+	}
+	file := &inventoryStateFile{
+		Endpoint: s.endpoint,
+		Buckets:  make([]inventoryBucketRecord, 0, len(s.last.buckets)),
+		Users:    make([]inventoryUserRecord, 0, len(s.last.users)),
+	}
+	for name, b := range s.last.buckets {
+		file.Buckets = append(file.Buckets, inventoryBucketRecord{name, b.owner, b.quota})
+	}
+	for uid, u := range s.last.users {
+		r := inventoryUserRecord{ID: uid, Suspended: u.suspended, Quota: u.quota}
+		for key := range u.keys {
+			r.Keys = append(r.Keys, key)
+		}
+		sort.Strings(r.Keys)
+		file.Users = append(file.Users, r)
+	}
+	sort.Slice(file.Buckets, func(i, j int) bool { return file.Buckets[i].Name < file.Buckets[j].Name })
+	sort.Slice(file.Users, func(i, j int) bool { return file.Users[i].ID < file.Users[j].ID })
+	return s.file.write(file)
+}
+
+// inventoryWatcher takes a snapshot of the users and buckets matching the filters every
+// interval and sends the changes from the last one as events. The last snapshot is kept
+// if the events fail to be sent, so they are sent again with the next changes.
+type inventoryWatcher struct {
+	client  *radosgw.Client
+	cluster string
+	config  *InventoryEventsConfig
+	filters *labelFilters
+	logger  *slog.Logger
+	http    *http.Client
+	state   *inventoryState
+
+	// events counts the sent events by type.
+	events *prometheus.CounterVec
+
+	// errors counts the failures of taking the snapshots and sending the events.
+	errors prometheus.Counter
+}
+
+func newInventoryWatcher(client *radosgw.Client, cluster string, config *InventoryEventsConfig,
+	filters *labelFilters, logger *slog.Logger) *inventoryWatcher {
+	return &inventoryWatcher{
+		client:  client,
+		cluster: cluster,
+		config:  config,
+		filters: filters,
+		logger:  logger.With("endpoint", client.Endpoint()),
+		http:    &http.Client{Timeout: inventoryWebhookTimeout},
+		state:   inventoryStateFor(client.Endpoint()),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "inventory",
+			Name:      "events_total",
+			Help:      "total number of the sent change events of the users and buckets by type",
+		}, []string{"type"}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "inventory_errors_total",
+			Help:      "total number of failures of taking the inventory snapshots or sending the events",
+		}),
+	}
+}
+
+func (w *inventoryWatcher) Describe(ch chan<- *prometheus.Desc) {
+	w.events.Describe(ch)
+	w.errors.Describe(ch)
+}
+
+func (w *inventoryWatcher) Collect(ch chan<- prometheus.Metric) {
+	w.events.Collect(ch)
+	w.errors.Collect(ch)
+}
+
+// Run - compare the inventory every interval until the stop channel is closed, the first
+// snapshot is taken at once as the base of the changes if there is no last one.
+func (w *inventoryWatcher) Run(stop <-chan struct{}) {
+	interval := w.config.Interval
+	if interval == 0 {
+		interval = defaultInventoryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.watch(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// watch - take a snapshot and send the changes from the last one
+func (w *inventoryWatcher) watch(now time.Time) {
+	w.state.mtx.Lock()
+	defer w.state.mtx.Unlock()
+	if !w.state.loaded {
+		if err := w.state.load(); err != nil {
+			w.errors.Inc()
+			w.logger.Error("load the inventory state failed", "err", err)
+			return
+		}
+		w.state.loaded = true
+	}
+	snapshot, err := w.snapshot()
+	if err != nil {
+		w.errors.Inc()
+		w.logger.Error("take the inventory snapshot failed", "err", err)
+		return
+	}
+	if w.state.last == nil {
+		w.update(snapshot)
+		return
+	}
+	events := diffInventory(w.state.last, snapshot, w.cluster, now.UTC())
+	if len(events) != 0 {
+		if err := w.send(events); err != nil {
+			w.errors.Inc()
+			w.logger.Error("send the inventory events failed", "events", len(events), "err", err)
+			return
+		}
+		for _, e := range events {
+			w.events.WithLabelValues(e.Type).Inc()
+		}
+		w.logger.Debug("send the inventory events succeeded", "events", len(events))
+	}
+	w.update(snapshot)
+}
+
+// update - replace the last snapshot, which is only kept in memory if failed to persist
+func (w *inventoryWatcher) update(snapshot *inventorySnapshot) {
+	w.state.last = snapshot
+	if err := w.state.save(); err != nil {
+		w.errors.Inc()
+		w.logger.Error("save the inventory state failed", "err", err)
+	}
+}
+
+// snapshot - get the buckets with the stats and the users matching the filters, the users
+// deleted after being listed are left out
+func (w *inventoryWatcher) snapshot() (*inventorySnapshot, error) {
+	status, bucketStats, err := w.client.GetBucket("", "", true)
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	result := &inventorySnapshot{
+		buckets: make(map[string]inventoryBucket, len(bucketStats)),
+		users:   make(map[string]inventoryUser),
+	}
+	for i := range bucketStats {
+		stats := bucketStats[i].Stats
+		if stats == nil {
+			continue
+		}
+		tenant, _, bucket := tenantLabels(stats.Owner, stats.Bucket)
+		if len(stats.Tenant) != 0 {
+			tenant = stats.Tenant
+		}
+		name := radosgw.JoinBucketName(tenant, bucket)
+		if !w.filters.matchBucket(stats.Owner, name) {
+			continue
+		}
+		result.buckets[name] = inventoryBucket{
+			owner: stats.Owner,
+			quota: stats.BucketQuota,
+		}
+	}
+	status, uids, err := w.client.ListUsers()
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	for _, uid := range uids {
+		if !w.filters.matchUser(uid) {
+			continue
+		}
+		status, info, err := w.client.GetUser(uid)
+		if isNoSuchUser(status) {
+			continue
+		}
+		if err != nil || status > 200 {
+			return nil, newScrapeError(status, err)
+		}
+		user := inventoryUser{
+			suspended: info.Suspended != 0,
+			quota:     info.UserQuota,
+			keys:      make(map[string]bool, len(info.Keys)),
+		}
+		for _, key := range info.Keys {
+			user.keys[key.AccessKey] = true
+		}
+		result.users[uid] = user
+	}
+	return result, nil
+}
+
+// diffInventory - get the changes between the snapshots, the users only in the new one
+// have no events
+func diffInventory(before, after *inventorySnapshot, cluster string, now time.Time) []inventoryEvent {
+	result := make([]inventoryEvent, 0)
+	event := func(eventType, user, bucket string) inventoryEvent {
+		return inventoryEvent{Time: now, Type: eventType, Cluster: cluster, User: user, Bucket: bucket}
+	}
+	for name, b := range after.buckets {
+		last, ok := before.buckets[name]
+		switch {
+		case !ok:
+			result = append(result, event(eventBucketCreated, b.owner, name))
+			continue
+		case last.owner != b.owner:
+			e := event(eventOwnerChanged, b.owner, name)
+			e.Old, e.New = last.owner, b.owner
+			result = append(result, e)
+		}
+		if last.quota != b.quota {
+			e := event(eventQuotaChanged, b.owner, name)
+			e.Old, e.New = last.quota, b.quota
+			result = append(result, e)
+		}
+	}
+	for name, b := range before.buckets {
+		if _, ok := after.buckets[name]; !ok {
+			result = append(result, event(eventBucketDeleted, b.owner, name))
+		}
+	}
+	for uid := range before.users {
+		if _, ok := after.users[uid]; !ok {
+			result = append(result, event(eventUserDeleted, uid, ""))
+		}
+	}
+	for uid, u := range after.users {
+		last, ok := before.users[uid]
+		if !ok {
+			continue
+		}
+		if u.suspended && !last.suspended {
+			result = append(result, event(eventUserSuspended, uid, ""))
+		}
+		if last.quota != u.quota {
+			e := event(eventQuotaChanged, uid, "")
+			e.Old, e.New = last.quota, u.quota
+			result = append(result, e)
+		}
+		for key := range u.keys {
+			if !last.keys[key] {
+				e := event(eventKeyAdded, uid, "")
+				e.AccessKey = key
+				result = append(result, e)
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].User != result[j].User {
+			return result[i].User < result[j].User
+		}
+		if result[i].Bucket != result[j].Bucket {
+			return result[i].Bucket < result[j].Bucket
+		}
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].AccessKey < result[j].AccessKey
+	})
+	return result
+}
+
+// send - append the events to the JSON-lines file or post them to the webhook
+func (w *inventoryWatcher) send(events []inventoryEvent) error {
+	if len(w.config.WebhookURL) != 0 {
+		body, err := json.Marshal(events)
+		if err != nil {
+			return err
+		}
+		resp, err := w.http.Post(w.config.WebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status code %d of the webhook", resp.StatusCode)
+		}
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	if w.config.File == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	file, err := os.OpenFile(w.config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,124 @@
+// inventory_test.go - test the change events of the users and buckets
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+// readEvents - get the events appended to the file
+func readEvents(t *testing.T, file string) []inventoryEvent {
+	t.Helper()
+	content, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("open the events failed: %v", err)
+	}
+	defer content.Close()
+	result := make([]inventoryEvent, 0)
+	scanner := bufio.NewScanner(content)
+	for scanner.Scan() {
+		e := inventoryEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("parse the event %s failed: %v", scanner.Text(), err)
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func TestInventoryWatcher(t *testing.T) {
+	f := newFakeRadosgw(t)
+	for _, uid := range []string{"alice", "bob", "tmp-carol"} {
+		f.addUser(&radosgw.UserType{UserID: uid})
+	}
+	f.setBuckets(testBucketStats("alice", "b1", 0), testBucketStats("bob", "b2", 0),
+		testBucketStats("alice", "tmp-b3", 0))
+
+	// The deleted users are still listed, so their requests are not found
+	f.handle("GET /admin/metadata/user", func(w http.ResponseWriter, r *http.Request) {
+		writeFakeJSON(w, []string{"alice", "bob", "ghost", "tmp-carol"})
+	})
+	filters, err := newLabelFilters(&FiltersConfig{
+		Users:   FilterConfig{Exclude: "tmp-.*"},
+		Buckets: FilterConfig{Exclude: "tmp-.*"},
+	})
+	if err != nil {
+		t.Fatalf("create the filters failed: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "events.json")
+	w := newInventoryWatcher(f.client, "ceph-a", &InventoryEventsConfig{File: file}, filters,
+		testLogger())
+	now := testHour(10, 0)
+	w.watch(now)
+
+	// The changes of the filtered out users and buckets have no events
+	f.deleteUser("bob")
+	f.addUser(&radosgw.UserType{UserID: "tmp-carol", Suspended: 1})
+	f.setBuckets(testBucketStats("alice", "b1", 0), testBucketStats("alice", "b4", 0))
+	w.watch(now.Add(time.Minute))
+
+	want := []inventoryEvent{
+		{Type: eventBucketCreated, User: "alice", Bucket: "b4"},
+		{Type: eventUserDeleted, User: "bob"},
+		{Type: eventBucketDeleted, User: "bob", Bucket: "b2"},
+	}
+	got := readEvents(t, file)
+	if len(got) != len(want) {
+		t.Fatalf("got events %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Type != want[i].Type || got[i].User != want[i].User || got[i].Bucket != want[i].Bucket ||
+			got[i].Cluster != "ceph-a" {
+			t.Errorf("got event %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestInventoryWatcherState(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.addUser(&radosgw.UserType{UserID: "alice"})
+	f.setBuckets(testBucketStats("alice", "b1", 0))
+	dir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "events.json")
+	filters, err := newLabelFilters(&FiltersConfig{})
+	if err != nil {
+		t.Fatalf("create the filters failed: %v", err)
+	}
+	newWatcher := func() *inventoryWatcher {
+		return newInventoryWatcher(f.client, "ceph-a", &InventoryEventsConfig{File: file}, filters, testLogger())
+	}
+	endpointStatesMtx.Lock()
+	endpointStates[endpointStateKey{"inventory", f.server.URL}] = newInventoryState(f.server.URL, dir)
+	endpointStatesMtx.Unlock()
+	newWatcher().watch(testHour(10, 0))
+
+	// The watcher rebuilt by the configuration reload takes over the last snapshot
+	reloaded := newWatcher()
+	reloaded.watch(testHour(10, 0))
+	f.setBuckets(testBucketStats("alice", "b1", 0), testBucketStats("alice", "b2", 0))
+	reloaded.watch(testHour(10, 5))
+	if events := readEvents(t, file); len(events) != 1 || events[0].Bucket != "b2" {
+		t.Errorf("got events %+v after the reload, want b2 created", events)
+	}
+
+	// The persisted snapshot is the base of the changes while the exporter is not running
+	f.addUser(&radosgw.UserType{UserID: "alice", Keys: []radosgw.KeyType{{User: "alice", AccessKey: "ak2"}}})
+	restarted := newWatcher()
+	restarted.state = newInventoryState(f.server.URL, dir)
+	restarted.watch(testHour(11, 0))
+	events := readEvents(t, file)
+	if len(events) != 2 || events[1].Type != eventKeyAdded || events[1].AccessKey != "ak2" {
+		t.Errorf("got events %+v after the restart, want only the key added", events)
+	}
+}
@@ -0,0 +1,105 @@
+// op_family.go - implement the mapping from the usage categories to the operation families
+
+package main
+
+const (
+	opFamilyRead      = "read"
+	opFamilyWrite     = "write"
+	opFamilyList      = "list"
+	opFamilyDelete    = "delete"
+	opFamilyMultipart = "multipart"
+	opFamilyAdmin     = "admin"
+
+	// opFamilyOther is the family of the categories not in the mapping.
+	opFamilyOther = "other"
+)
+
+// defaultOpFamilies maps the usage categories of radosgw, which are the names of the S3
+// and Swift operations, to the operation families.
+var defaultOpFamilies = map[string]string{
+	"get_obj":                   opFamilyRead,
+	"stat_obj":                  opFamilyRead,
+	"get_obj_attrs":             opFamilyRead,
+	"get_obj_tagging":           opFamilyRead,
+	"get_obj_retention":         opFamilyRead,
+	"get_obj_legal_hold":        opFamilyRead,
+	"get_obj_layout":            opFamilyRead,
+	"options_cors":              opFamilyRead,
+	"put_obj":                   opFamilyWrite,
+	"post_obj":                  opFamilyWrite,
+	"copy_obj":                  opFamilyWrite,
+	"put_obj_tagging":           opFamilyWrite,
+	"put_obj_retention":         opFamilyWrite,
+	"put_obj_legal_hold":        opFamilyWrite,
+	"set_obj_attrs":             opFamilyWrite,
+	"put_obj_acls":              opFamilyWrite,
+	"restore_obj":               opFamilyWrite,
+	"list_bucket":               opFamilyList,
+	"list_buckets":              opFamilyList,
+	"list_bucket_versions":      opFamilyList,
+	"stat_bucket":               opFamilyList,
+	"stat_account":              opFamilyList,
+	"delete_obj":                opFamilyDelete,
+	"delete_multi_obj":          opFamilyDelete,
+	"delete_obj_tagging":        opFamilyDelete,
+	"init_multipart":            opFamilyMultipart,
+	"complete_multipart":        opFamilyMultipart,
+	"abort_multipart":           opFamilyMultipart,
+	"list_multipart":            opFamilyMultipart,
+	"list_bucket_multiparts":    opFamilyMultipart,
+	"create_bucket":             opFamilyAdmin,
+	"delete_bucket":             opFamilyAdmin,
+	"get_acls":                  opFamilyAdmin,
+	"put_acls":                  opFamilyAdmin,
+	"get_cors":                  opFamilyAdmin,
+	"put_cors":                  opFamilyAdmin,
+	"delete_cors":               opFamilyAdmin,
+	"get_lifecycle":             opFamilyAdmin,
+	"put_lifecycle":             opFamilyAdmin,
+	"delete_lifecycle":          opFamilyAdmin,
+	"get_bucket_policy":         opFamilyAdmin,
+	"put_bucket_policy":         opFamilyAdmin,
+	"delete_bucket_policy":      opFamilyAdmin,
+	"get_bucket_tags":           opFamilyAdmin,
+	"put_bucket_tags":           opFamilyAdmin,
+	"delete_bucket_tags":        opFamilyAdmin,
+	"get_bucket_versioning":     opFamilyAdmin,
+	"set_bucket_versioning":     opFamilyAdmin,
+	"get_bucket_location":       opFamilyAdmin,
+	"get_bucket_logging":        opFamilyAdmin,
+	"get_bucket_website":        opFamilyAdmin,
+	"set_bucket_website":        opFamilyAdmin,
+	"delete_bucket_website":     opFamilyAdmin,
+	"get_bucket_encryption":     opFamilyAdmin,
+	"put_bucket_encryption":     opFamilyAdmin,
+	"get_request_payment":       opFamilyAdmin,
+	"set_request_payment":       opFamilyAdmin,
+	"get_bucket_replication":    opFamilyAdmin,
+	"put_bucket_replication":    opFamilyAdmin,
+	"delete_bucket_replication": opFamilyAdmin,
+	"get_bucket_object_lock":    opFamilyAdmin,
+	"put_bucket_object_lock":    opFamilyAdmin,
+}
+
+// opFamilies maps the usage categories to the operation families.
+type opFamilies map[string]string
+
+// newOpFamilies - create the mapping of the default one overridden by the given one
+func newOpFamilies(overrides map[string]string) opFamilies {
+	result := make(opFamilies, len(defaultOpFamilies)+len(overrides))
+	for category, family := range defaultOpFamilies {
+		result[category] = family
+	}
+	for category, family := range overrides {
+		result[category] = family
+	}
+	return result
+}
+
+// family - get the operation family of the category, it is other if not in the mapping
+func (f opFamilies) family(category string) string {
+	if family, ok := f[category]; ok {
+		return family
+	}
+	return opFamilyOther
+}
@@ -0,0 +1,518 @@
+// policy.go - implement the policy engine applying the admin rules to the users
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const (
+	defaultPolicyInterval = 5 * time.Minute
+
+	policySetUserQuota = "set_user_quota"
+	policySuspendUser  = "suspend_user"
+)
+
+var (
+	policyStateDir = flag.String("policies.state.dir", "",
+		"directory to persist the users existing when the new_users_only policies started, required by them")
+)
+
+// policyAuditRecord is an admin mutation performed by a rule, or only planned in dry run.
+type policyAuditRecord struct {
+	Time    time.Time   `json:"time"`
+	Cluster string      `json:"cluster,omitempty"`
+	Rule    string      `json:"rule"`
+	Action  string      `json:"action"`
+	User    string      `json:"user"`
+	DryRun  bool        `json:"dry_run"`
+	Reason  string      `json:"reason,omitempty"`
+	Old     interface{} `json:"old,omitempty"`
+	New     interface{} `json:"new,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// policyRule is a compiled rule of the policies.
+type policyRule struct {
+	*PolicyRuleConfig
+	users labelFilter
+}
+
+// policyBreach is the usage of a user since its failed ratio started exceeding.
+type policyBreach struct {
+	since time.Time
+	usage usageValue
+}
+
+// policyStateFile is the content of the persisted state file.
+type policyStateFile struct {
+	Endpoint string   `json:"endpoint"`
+	Baseline []string `json:"baseline"`
+}
+
+// policyState has the baseline of the users existing in the first interval, the other
+// ones are the new users. It is persisted so the users created while the exporter is not
+// running are still new. The usage and the breaches are only kept in memory, and taken
+// over by the engine rebuilt by the configuration reload.
+type policyState struct {
+	endpoint string
+	file     stateFile
+
+	// mtx is held by the engine applying the rules of the endpoint.
+	mtx      sync.Mutex
+	loaded   bool
+	baseline map[string]bool
+
+	// usage is the usage log entries since the hour of the last interval, which are
+	// compared with the next ones to get the operations in the interval. evaluated is
+	// when it was fetched, it is zero before the first interval.
+	usage     map[openUsageKey]usageValue
+	evaluated time.Time
+
+	// breaches are the usage of the users since their failed ratios started exceeding by
+	// the rule names and user ids.
+	breaches map[string]map[string]*policyBreach
+
+	// planned are the mutations recorded in dry run by the rule names and user ids.
+	planned map[string]map[string]bool
+}
+
+// policyStateFor - get the shared state of the radosgw endpoint
+func policyStateFor(endpoint string) *policyState {
+	return endpointState("policy", endpoint, func() interface{} {
+		return newPolicyState(endpoint, *policyStateDir)
+	}).(*policyState)
+}
+
+// newPolicyState - create the state of the radosgw endpoint persisted into the directory
+func newPolicyState(endpoint, dir string) *policyState {
+	return &policyState{
+		endpoint: endpoint,
+		file:     newStateFile("policy", endpoint, dir),
+		breaches: make(map[string]map[string]*policyBreach),
+		planned:  make(map[string]map[string]bool),
+	}
+}
+
+// rules - keep the breaches and planned mutations of the rules only, the ones of the rules
+// removed by the configuration reload are dropped
+func (s *policyState) rules(rules []policyRule) {
+	names := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		names[r.Name] = true
+		if s.breaches[r.Name] == nil {
+			s.breaches[r.Name] = make(map[string]*policyBreach)
+		}
+		if s.planned[r.Name] == nil {
+			s.planned[r.Name] = make(map[string]bool)
+		}
+	}
+	for name := range s.breaches {
+		if !names[name] {
+			delete(s.breaches, name)
+		}
+	}
+	for name := range s.planned {
+		if !names[name] {
+			delete(s.planned, name)
+		}
+	}
+}
+
+// users - get the users of the baseline, it is taken from the given users if there is not
+// one yet. The returned map is never modified.
+func (s *policyState) users(uids []string) (map[string]bool, error) {
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		s.loaded = true
+	}
+	if s.baseline == nil {
+		baseline := make(map[string]bool, len(uids))
+		for _, uid := range uids {
+			baseline[uid] = true
+		}
+		s.baseline = baseline
+		if err := s.save(); err != nil {
+			s.baseline = nil
+			return nil, err
+		}
+	}
+	return s.baseline, nil
+}
+
+// load - restore the baseline from the state file if it exists
+func (s *policyState) load() error {
+	file := &policyStateFile{}
+	if ok, err := s.file.read(file); !ok {
+		return err
+	}
+	s.baseline = make(map[string]bool, len(file.Baseline))
+	for _, uid := range file.Baseline {
+		s.baseline[uid] = true
+	}
+	return nil
+}
+
+// save - write the baseline into the state file
+func (s *policyState) save() error {
+	if !s.file.persisted() {
+		return nil
+	}
+	file := &policyStateFile{
+		Endpoint: s.endpoint,
+		Baseline: make([]string, 0, len(s.baseline)),
+	}
+	for uid := range s.baseline {
+		file.Baseline = append(file.Baseline, uid)
+	}
+	sort.Strings(file.Baseline)
+	return s.file.write(file)
+}
+
+// policyEngine applies the rules to all users every interval. The rules are level based,
+// a user no longer matching a rule after its mutation is left alone, and a failed mutation
+// is tried again in the next interval. In dry run each mutation is only recorded once. The
+// admin user of the exporter is never suspended.
+type policyEngine struct {
+	client  *radosgw.Client
+	cluster string
+	config  *PoliciesConfig
+	rules   []policyRule
+	logger  *slog.Logger
+	state   *policyState
+
+	// actions counts the mutations by the rule, action and whether in dry run.
+	actions *prometheus.CounterVec
+
+	// errors counts the failures of listing the users, the mutations and the audit log.
+	errors prometheus.Counter
+}
+
+func newPolicyEngine(client *radosgw.Client, cluster string, config *PoliciesConfig,
+	logger *slog.Logger) (*policyEngine, error) {
+	e := &policyEngine{
+		client:  client,
+		cluster: cluster,
+		config:  config,
+		rules:   make([]policyRule, 0, len(config.Rules)),
+		logger:  logger.With("endpoint", client.Endpoint()),
+		state:   policyStateFor(client.Endpoint()),
+		actions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "policy_actions_total",
+			Help:      "total number of the admin mutations performed by the policies, or only recorded in dry run",
+		}, []string{"rule", "action", "dry_run"}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "policy_errors_total",
+			Help:      "total number of failures of applying the policies or writing the audit log",
+		}),
+	}
+	for i := range config.Rules {
+		users, err := newLabelFilter("users", config.Rules[i].Users)
+		if err != nil {
+			return nil, fmt.Errorf("policies: rule %s: %v", config.Rules[i].Name, err)
+		}
+		e.rules = append(e.rules, policyRule{&config.Rules[i], users})
+	}
+	return e, nil
+}
+
+func (e *policyEngine) Describe(ch chan<- *prometheus.Desc) {
+	e.actions.Describe(ch)
+	e.errors.Describe(ch)
+}
+
+func (e *policyEngine) Collect(ch chan<- prometheus.Metric) {
+	e.actions.Collect(ch)
+	e.errors.Collect(ch)
+}
+
+// Run - apply the rules every interval until the stop channel is closed, the first
+// interval starts at once.
+func (e *policyEngine) Run(stop <-chan struct{}) {
+	interval := e.config.Interval
+	if interval == 0 {
+		interval = defaultPolicyInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.apply(time.Now()); err != nil {
+			e.errors.Inc()
+			e.logger.Error("apply the policies failed", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// apply - get all users and their operations in the interval if needed, and apply each
+// rule to them. The users failed to get are skipped in this interval.
+func (e *policyEngine) apply(now time.Time) error {
+	e.state.mtx.Lock()
+	defer e.state.mtx.Unlock()
+	e.state.rules(e.rules)
+	status, uids, err := e.client.ListUsers()
+	if err != nil || status > 200 {
+		return newScrapeError(status, err).err
+	}
+	users := make(map[string]*radosgw.UserType, len(uids))
+	for _, uid := range uids {
+		// The users may be deleted after being listed
+		status, info, err := e.client.GetUser(uid)
+		if isNoSuchUser(status) {
+			continue
+		}
+		if err != nil || status > 200 {
+			e.errors.Inc()
+			e.logger.Error("get the user of the policies failed", "user", uid,
+				"err", newScrapeError(status, err))
+			continue
+		}
+		users[uid] = info
+	}
+	var (
+		baseline map[string]bool
+		usage    map[string]usageValue
+		adminKey string
+	)
+	since := e.state.evaluated
+	for _, r := range e.rules {
+		if r.NewUsersOnly && baseline == nil {
+			if baseline, err = e.state.users(uids); err != nil {
+				return fmt.Errorf("update the policy state failed: %v", err)
+			}
+		}
+		if r.Action == policySuspendUser && usage == nil {
+			if adminKey, err = e.client.AccessKey(); err != nil {
+				return err
+			}
+			if usage, err = e.updateUsage(now); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, uid := range uids {
+		user, ok := users[uid]
+		if !ok {
+			continue
+		}
+		for _, r := range e.rules {
+			if !r.users.match(uid) {
+				continue
+			}
+			switch r.Action {
+			case policySetUserQuota:
+				if user.UserQuota.Enabled {
+					continue
+				}
+				if r.NewUsersOnly && baseline[uid] {
+					continue
+				}
+				e.setUserQuota(r, uid, user, now)
+			case policySuspendUser:
+				// The exporter must not lock itself out by suspending its admin user
+				if ownsAccessKey(user, adminKey) {
+					continue
+				}
+				// The first interval is only the base of the operations
+				if !since.IsZero() {
+					e.suspendUser(r, uid, user, usage[uid], since, now)
+				}
+			}
+		}
+	}
+	listed := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		listed[uid] = true
+	}
+	for _, breaches := range e.state.breaches {
+		for uid := range breaches {
+			if !listed[uid] {
+				delete(breaches, uid)
+			}
+		}
+	}
+	return nil
+}
+
+// ownsAccessKey - check whether the access key is one of the user
+func ownsAccessKey(user *radosgw.UserType, accessKey string) bool {
+	for _, key := range user.Keys {
+		if key.AccessKey == accessKey {
+			return true
+		}
+	}
+	return false
+}
+
+// updateUsage - fetch the usage log entries since the hour of the last interval, and get
+// the operations of each user in the interval by comparing them with the last ones. An
+// entry shrunk by the trimming of the usage log is counted from zero again.
+func (e *policyEngine) updateUsage(now time.Time) (map[string]usageValue, error) {
+	start := now.UTC().Truncate(time.Hour)
+	if !e.state.evaluated.IsZero() {
+		start = e.state.evaluated.UTC().Truncate(time.Hour)
+	}
+	status, usage, err := e.client.GetUsage("", &start, nil, false, true)
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err).err
+	}
+	entries := make(map[openUsageKey]usageValue)
+	for i := range usage.Entries {
+		for _, b := range usage.Entries[i].Buckets {
+			for _, c := range b.Categories {
+				okey := openUsageKey{usageKey{usage.Entries[i].User, b.Bucket, c.Category}, b.Epoch}
+				value := entries[okey]
+				value.add(usageValue{c.BytesSent, c.BytesReceived, c.Ops, c.SuccessfulOps})
+				entries[okey] = value
+			}
+		}
+	}
+	result := make(map[string]usageValue)
+	for okey, value := range entries {
+		if last, ok := e.state.usage[okey]; ok && !value.shrunk(last) {
+			value = usageValue{value.BytesSent - last.BytesSent, value.BytesReceived - last.BytesReceived,
+				value.Ops - last.Ops, value.SuccessfulOps - last.SuccessfulOps}
+		}
+		total := result[okey.User]
+		total.add(value)
+		result[okey.User] = total
+	}
+	e.state.usage, e.state.evaluated = entries, now
+	return result, nil
+}
+
+// setUserQuota - enable the user quota of the rule, the zero limits are unlimited
+func (e *policyEngine) setUserQuota(r policyRule, uid string, user *radosgw.UserType, now time.Time) {
+	quota := radosgw.QuotaType{MaxSize: -1, MaxObjects: -1, Enabled: true}
+	if r.MaxSize > 0 {
+		quota.MaxSize = r.MaxSize
+	}
+	if r.MaxObjects > 0 {
+		quota.MaxObjects = r.MaxObjects
+	}
+	record := e.record(r, uid, now)
+	record.Reason = "user quota is not enabled"
+	record.Old, record.New = user.UserQuota, quota
+	e.mutate(record, func() error {
+		status, err := e.client.SetQuota(uid, "user", quota.MaxObjects, quota.MaxSize, true, "")
+		if err != nil || status > 200 {
+			return newScrapeError(status, err).err
+		}
+		return nil
+	})
+}
+
+// suspendUser - suspend the user once its failed ratio of the operations in each interval
+// keeps exceeding for the duration of the rule, with at least the min operations in total
+// since then. The ratio is reset by an interval without operations or not exceeding it.
+func (e *policyEngine) suspendUser(r policyRule, uid string, user *radosgw.UserType,
+	usage usageValue, since, now time.Time) {
+	breaches := e.state.breaches[r.Name]
+	if user.Suspended != 0 || usage.Ops == 0 ||
+		float64(usage.Ops-usage.SuccessfulOps)/float64(usage.Ops) <= r.FailedRatio {
+		delete(breaches, uid)
+		return
+	}
+	breach, ok := breaches[uid]
+	if !ok {
+		breach = &policyBreach{since: since}
+		breaches[uid] = breach
+	}
+	breach.usage.add(usage)
+	total := breach.usage
+	if now.Sub(breach.since) < r.For || total.Ops < r.MinOps {
+		return
+	}
+	record := e.record(r, uid, now)
+	record.Reason = fmt.Sprintf("%d of %d operations failed since %s", total.Ops-total.SuccessfulOps,
+		total.Ops, breach.since.UTC().Format(time.RFC3339))
+	record.Old, record.New = false, true
+	e.mutate(record, func() error {
+		status, err := e.client.SuspendUser(uid, true)
+		if err != nil || status > 200 {
+			return newScrapeError(status, err).err
+		}
+		return nil
+	})
+}
+
+func (e *policyEngine) record(r policyRule, uid string, now time.Time) *policyAuditRecord {
+	return &policyAuditRecord{
+		Time:    now.UTC(),
+		Cluster: e.cluster,
+		Rule:    r.Name,
+		Action:  r.Action,
+		User:    uid,
+		DryRun:  e.config.DryRun,
+	}
+}
+
+// mutate - perform the mutation unless in dry run and append it to the audit log, the
+// mutations in dry run are only recorded once
+func (e *policyEngine) mutate(record *policyAuditRecord, fn func() error) {
+	if record.DryRun {
+		if e.state.planned[record.Rule][record.User] {
+			return
+		}
+		e.state.planned[record.Rule][record.User] = true
+	} else if err := fn(); err != nil {
+		e.errors.Inc()
+		record.Error = err.Error()
+		e.logger.Error("perform the policy action failed", "rule", record.Rule,
+			"action", record.Action, "user", record.User, "err", err)
+	}
+	if len(record.Error) == 0 {
+		e.actions.WithLabelValues(record.Rule, record.Action, strconv.FormatBool(record.DryRun)).Inc()
+		e.logger.Info("perform the policy action succeeded", "rule", record.Rule,
+			"action", record.Action, "user", record.User, "dry_run", record.DryRun)
+	}
+	if err := e.audit(record); err != nil {
+		e.errors.Inc()
+		e.logger.Error("write the policy audit log failed", "file", e.config.AuditLog, "err", err)
+	}
+}
+
+// audit - append the record to the JSON-lines audit log
+func (e *policyEngine) audit(record *policyAuditRecord) error {
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(record); err != nil {
+		return err
+	}
+	if e.config.AuditLog == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	file, err := os.OpenFile(e.config.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
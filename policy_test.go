@@ -0,0 +1,278 @@
+// policy_test.go - test the policy engine applying the admin rules to the users
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+var testQuotaRule = PolicyRuleConfig{
+	Name:    "default-quota",
+	Action:  policySetUserQuota,
+	MaxSize: 1 << 30,
+}
+
+func newTestPolicyEngine(t *testing.T, f *fakeRadosgw, dryRun bool,
+	rules ...PolicyRuleConfig) *policyEngine {
+	t.Helper()
+	config := &PoliciesConfig{
+		DryRun:   dryRun,
+		AuditLog: filepath.Join(t.TempDir(), "audit.jsonl"),
+		Rules:    rules,
+	}
+	e, err := newPolicyEngine(f.client, "ceph-a", config, testLogger())
+	if err != nil {
+		t.Fatalf("create the policy engine failed: %v", err)
+	}
+	e.state = newPolicyState(f.server.URL, "")
+	return e
+}
+
+func applyPolicies(t *testing.T, e *policyEngine, now time.Time) {
+	t.Helper()
+	if err := e.apply(now); err != nil {
+		t.Fatalf("apply the policies failed: %v", err)
+	}
+}
+
+// readAudit - get the records of the audit log
+func readAudit(t *testing.T, e *policyEngine) []*policyAuditRecord {
+	t.Helper()
+	file, err := os.Open(e.config.AuditLog)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("open the audit log failed: %v", err)
+	}
+	defer file.Close()
+	var records []*policyAuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &policyAuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatalf("parse the audit record %s failed: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func hasUserQuota(f *fakeRadosgw, uid string) bool {
+	return f.user(uid).UserQuota.Enabled
+}
+
+func TestPolicyUsersFilter(t *testing.T) {
+	cases := []struct {
+		name  string
+		users FilterConfig
+		want  map[string]bool
+	}{
+		{"all", FilterConfig{}, map[string]bool{"team-a": true, "team-admin": true, "bob": true}},
+		{"included", FilterConfig{Include: "team-.*"}, map[string]bool{"team-a": true, "team-admin": true}},
+		{"excluded", FilterConfig{Include: "team-.*", Exclude: "team-admin"}, map[string]bool{"team-a": true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newFakeRadosgw(t)
+			for _, uid := range []string{"team-a", "team-admin", "bob"} {
+				f.addUser(&radosgw.UserType{UserID: uid})
+			}
+			rule := testQuotaRule
+			rule.Users = c.users
+			e := newTestPolicyEngine(t, f, false, rule)
+			applyPolicies(t, e, testHour(0, 0))
+			for _, uid := range []string{"team-a", "team-admin", "bob"} {
+				if hasUserQuota(f, uid) != c.want[uid] {
+					t.Errorf("got the user quota of %s enabled %v, want %v", uid, hasUserQuota(f, uid), c.want[uid])
+				}
+			}
+			if n := len(readAudit(t, e)); n != len(c.want) {
+				t.Errorf("got %d audit records, want %d", n, len(c.want))
+			}
+		})
+	}
+}
+
+func TestPolicyNewUsersOnly(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.addUser(&radosgw.UserType{UserID: "alice"})
+	dir := t.TempDir()
+	rule := testQuotaRule
+	rule.NewUsersOnly = true
+	e := newTestPolicyEngine(t, f, false, rule)
+	e.state = newPolicyState(f.server.URL, dir)
+	applyPolicies(t, e, testHour(0, 0))
+	if hasUserQuota(f, "alice") {
+		t.Errorf("got the user quota of the existing user enabled")
+	}
+	f.addUser(&radosgw.UserType{UserID: "bob"})
+	applyPolicies(t, e, testHour(0, 5))
+	if !hasUserQuota(f, "bob") {
+		t.Errorf("got the user quota of the new user not enabled")
+	}
+
+	// The baseline is kept across restarts
+	f.addUser(&radosgw.UserType{UserID: "carol"})
+	restarted := newTestPolicyEngine(t, f, false, rule)
+	restarted.state = newPolicyState(f.server.URL, dir)
+	applyPolicies(t, restarted, testHour(1, 0))
+	if hasUserQuota(f, "alice") || !hasUserQuota(f, "carol") {
+		t.Errorf("got the user quota of alice %v and carol %v after restarted, want false and true",
+			hasUserQuota(f, "alice"), hasUserQuota(f, "carol"))
+	}
+}
+
+func TestPolicySuspendUser(t *testing.T) {
+	type step struct {
+		ops, successful int64
+		suspended       bool
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{"exceeded for the duration", []step{{0, 0, false}, {60, 0, false}, {120, 0, true}}},
+		{"reset by a healthy interval", []step{{0, 0, false}, {60, 0, false}, {160, 100, false},
+			{220, 100, false}, {280, 100, true}}},
+		{"reset by an idle interval", []step{{0, 0, false}, {60, 0, false}, {60, 0, false},
+			{120, 0, false}}},
+		{"fewer operations", []step{{0, 0, false}, {10, 0, false}, {20, 0, false}, {30, 0, false}}},
+		{"first interval", []step{{1000, 0, false}}},
+	}
+	rule := PolicyRuleConfig{
+		Name:        "suspend-failing",
+		Action:      policySuspendUser,
+		FailedRatio: 0.5,
+		MinOps:      100,
+		For:         10 * time.Minute,
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newFakeRadosgw(t)
+			f.addUser(&radosgw.UserType{UserID: "alice"})
+			f.addUser(&radosgw.UserType{UserID: "bob"})
+			e := newTestPolicyEngine(t, f, false, rule)
+			for i, s := range c.steps {
+				f.setUsage(
+					fakeUsage{"alice", "b1", testHour(0, 0), "get_obj",
+						usageValue{Ops: s.ops, SuccessfulOps: s.successful}},
+					fakeUsage{"bob", "b2", testHour(0, 0), "get_obj",
+						usageValue{Ops: s.ops, SuccessfulOps: s.ops}})
+				applyPolicies(t, e, testHour(0, 5*i))
+				if suspended := f.user("alice").Suspended != 0; suspended != s.suspended {
+					t.Fatalf("step %d: got alice suspended %v, want %v", i, suspended, s.suspended)
+				}
+				if f.user("bob").Suspended != 0 {
+					t.Fatalf("step %d: got the healthy user suspended", i)
+				}
+			}
+			for _, r := range f.recorded("POST /admin/user") {
+				query := r.URL.Query()
+				if len(query) != 3 || query.Get("uid") != "alice" || query.Get("suspended") != "1" {
+					t.Errorf("got the suspend request %s, want only the uid and suspended", r.URL.RawQuery)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicySuspendUserReload(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.addUser(&radosgw.UserType{UserID: "alice"})
+	// The admin user of the exporter owns the access key of the client
+	f.addUser(&radosgw.UserType{UserID: "admin", Keys: []radosgw.KeyType{{User: "admin", AccessKey: "ak"}}})
+	rule := PolicyRuleConfig{
+		Name:        "suspend-failing",
+		Action:      policySuspendUser,
+		FailedRatio: 0.5,
+		MinOps:      100,
+		For:         10 * time.Minute,
+	}
+	config := &PoliciesConfig{AuditLog: filepath.Join(t.TempDir(), "audit.jsonl"), Rules: []PolicyRuleConfig{rule}}
+	newEngine := func() *policyEngine {
+		e, err := newPolicyEngine(f.client, "ceph-a", config, testLogger())
+		if err != nil {
+			t.Fatalf("create the policy engine failed: %v", err)
+		}
+		return e
+	}
+	setOps := func(ops int64) {
+		f.setUsage(
+			fakeUsage{"alice", "b1", testHour(0, 0), "get_obj", usageValue{Ops: ops}},
+			fakeUsage{"admin", "b2", testHour(0, 0), "get_obj", usageValue{Ops: ops}})
+	}
+	e := newEngine()
+	for i, ops := range []int64{0, 60} {
+		setOps(ops)
+		applyPolicies(t, e, testHour(0, 5*i))
+	}
+
+	// The engine rebuilt by the configuration reload keeps the breaches of the last one
+	setOps(120)
+	applyPolicies(t, newEngine(), testHour(0, 10))
+	if f.user("alice").Suspended == 0 {
+		t.Errorf("got alice not suspended after the reload")
+	}
+	if f.user("admin").Suspended != 0 {
+		t.Errorf("got the admin user of the exporter suspended")
+	}
+}
+
+func TestPolicyDryRun(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.addUser(&radosgw.UserType{UserID: "alice"})
+	e := newTestPolicyEngine(t, f, true, testQuotaRule)
+	for i := 0; i < 3; i++ {
+		applyPolicies(t, e, testHour(0, 5*i))
+	}
+	if hasUserQuota(f, "alice") || len(f.recorded("PUT /admin/user")) != 0 {
+		t.Errorf("got the user quota set in dry run")
+	}
+	records := readAudit(t, e)
+	if len(records) != 1 || !records[0].DryRun || records[0].User != "alice" {
+		t.Errorf("got the audit records %+v, want the dry run of alice once", records)
+	}
+}
+
+func TestPolicyUserErrors(t *testing.T) {
+	f := newFakeRadosgw(t)
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		f.addUser(&radosgw.UserType{UserID: uid})
+	}
+	f.handle("GET /admin/user", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("uid") {
+		case "bob":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "carol":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			f.getUser(w, r)
+		}
+	})
+	e := newTestPolicyEngine(t, f, false, testQuotaRule)
+
+	// The users failed to get are skipped without aborting the others
+	applyPolicies(t, e, testHour(0, 0))
+	if !hasUserQuota(f, "alice") || hasUserQuota(f, "bob") || hasUserQuota(f, "carol") {
+		t.Errorf("got the user quota of alice %v, bob %v and carol %v, want only alice",
+			hasUserQuota(f, "alice"), hasUserQuota(f, "bob"), hasUserQuota(f, "carol"))
+	}
+	m := &dto.Metric{}
+	if err := e.errors.Write(m); err != nil {
+		t.Fatalf("write the errors metric failed: %v", err)
+	}
+	if v := m.GetCounter().GetValue(); v != 1 {
+		t.Errorf("got %v errors, want 1 of bob", v)
+	}
+}
@@ -0,0 +1,81 @@
+// probe.go - implement the cache of the collectors of the /probe targets
+
+package main
+
+import (
+	"container/list"
+	"flag"
+	"time"
+)
+
+var (
+	probeCacheSize = flag.Int("probe.cache.size", 100,
+		"max number of the cached collectors of the /probe targets")
+	probeCacheTTL = flag.Duration("probe.cache.ttl", 10*time.Minute,
+		"how long the collector of a /probe target is cached since it was last used")
+)
+
+// probeEntry is a cached collector of a probe target by the auth module and target.
+type probeEntry struct {
+	key       string
+	collector *RadosgwCollector
+	used      time.Time
+}
+
+// probeCache keeps the collectors of the most recently used probe targets, the least
+// recently used one is evicted once the cache is full, and the ones unused for the ttl
+// are expired. It is not safe for concurrent use.
+type probeCache struct {
+	size    int
+	ttl     time.Duration
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+func newProbeCache(size int, ttl time.Duration) *probeCache {
+	return &probeCache{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+}
+
+// get - get the cached collector and mark it used, nil if not cached or expired
+func (c *probeCache) get(key string, now time.Time) *RadosgwCollector {
+	c.expire(now)
+	elem, ok := c.index[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*probeEntry)
+	entry.used = now
+	c.entries.MoveToFront(elem)
+	return entry.collector
+}
+
+// add - cache the collector, and evict the least recently used ones beyond the size
+func (c *probeCache) add(key string, collector *RadosgwCollector, now time.Time) {
+	if elem, ok := c.index[key]; ok {
+		c.entries.Remove(elem)
+	}
+	c.index[key] = c.entries.PushFront(&probeEntry{key, collector, now})
+	for c.entries.Len() > c.size && c.entries.Len() > 0 {
+		c.remove(c.entries.Back())
+	}
+}
+
+// expire - drop the collectors unused for the ttl
+func (c *probeCache) expire(now time.Time) {
+	for elem := c.entries.Back(); elem != nil; elem = c.entries.Back() {
+		if now.Sub(elem.Value.(*probeEntry).used) < c.ttl {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+func (c *probeCache) remove(elem *list.Element) {
+	c.entries.Remove(elem)
+	delete(c.index, elem.Value.(*probeEntry).key)
+}
@@ -0,0 +1,83 @@
+// probe_test.go - test the collector cache and the target allowlist of /probe
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeCacheEviction(t *testing.T) {
+	c := newProbeCache(2, time.Minute)
+	now := testHour(0, 0)
+	a, b, d := &RadosgwCollector{}, &RadosgwCollector{}, &RadosgwCollector{}
+	c.add("a", a, now)
+	c.add("b", b, now)
+
+	// The least recently used one is evicted beyond the size
+	if c.get("a", now) != a {
+		t.Fatalf("got the cached collector of a missing")
+	}
+	c.add("d", d, now)
+	if c.get("b", now) != nil {
+		t.Errorf("got the least recently used collector of b kept")
+	}
+	if c.get("a", now) != a || c.get("d", now) != d {
+		t.Errorf("got the recently used collectors evicted")
+	}
+
+	// Adding the cached key again replaces it without evicting others
+	replaced := &RadosgwCollector{}
+	c.add("a", replaced, now)
+	if c.get("a", now) != replaced || c.get("d", now) != d || c.entries.Len() != 2 {
+		t.Errorf("got %d entries after replaced, want the replaced a and d", c.entries.Len())
+	}
+}
+
+func TestProbeCacheTTL(t *testing.T) {
+	c := newProbeCache(10, time.Minute)
+	now := testHour(0, 0)
+	a, b := &RadosgwCollector{}, &RadosgwCollector{}
+	c.add("a", a, now)
+	c.add("b", b, now)
+
+	// Using a collector extends its ttl
+	if c.get("a", now.Add(50*time.Second)) != a {
+		t.Fatalf("got the collector of a expired within the ttl")
+	}
+	if c.get("b", now.Add(time.Minute)) != nil {
+		t.Errorf("got the collector of b unused for the ttl")
+	}
+	if c.get("a", now.Add(100*time.Second)) != a {
+		t.Errorf("got the collector of a expired after used")
+	}
+	if c.get("a", now.Add(200*time.Second)) != nil || len(c.index) != 0 {
+		t.Errorf("got %d collectors left after all expired", len(c.index))
+	}
+}
+
+func TestAuthModuleAllowTarget(t *testing.T) {
+	module := &AuthModuleConfig{Targets: []string{"http://rgw-[ab]:8080", "https://rgw\\.example\\.com"}}
+	cases := []struct {
+		target string
+		want   bool
+	}{
+		{"http://rgw-a:8080", true},
+		{"http://rgw-b:8080", true},
+		{"http://rgw-c:8080", false},
+		{"https://rgw.example.com", true},
+		// The patterns match the whole target
+		{"http://rgw-a:80801", false},
+		{"http://evil/?http://rgw-a:8080", false},
+		{"https://rgw.example.com.evil", false},
+		{"https://rgwxexample.com", false},
+	}
+	for _, c := range cases {
+		if got := module.allowTarget(c.target); got != c.want {
+			t.Errorf("got target %s allowed %v, want %v", c.target, got, c.want)
+		}
+	}
+	if (&AuthModuleConfig{}).allowTarget("http://rgw-a:8080") {
+		t.Errorf("got the target allowed without any targets")
+	}
+}
@@ -0,0 +1,343 @@
+// quota_notify.go - implement the webhook notifications of the quota utilization
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	notificationFiring   = "firing"
+	notificationResolved = "resolved"
+
+	defaultNotificationRetries = 5
+	defaultNotificationBackoff = time.Second
+	notificationTimeout        = 10 * time.Second
+	notificationQueueSize      = 1024
+)
+
+// quotaUtilization is the usage of a user or bucket against its quota, the max limits are
+// negative if unlimited.
+type quotaUtilization struct {
+	Kind        string `json:"kind"`
+	Tenant      string `json:"tenant"`
+	User        string `json:"user"`
+	Bucket      string `json:"bucket,omitempty"`
+	UsedBytes   int64  `json:"used_bytes"`
+	MaxBytes    int64  `json:"max_bytes"`
+	UsedObjects int64  `json:"used_objects"`
+	MaxObjects  int64  `json:"max_objects"`
+}
+
+// ratio - get the highest utilization of the limits, 0 if unlimited
+func (u *quotaUtilization) ratio() float64 {
+	result := 0.0
+	if u.MaxBytes > 0 {
+		result = float64(u.UsedBytes) / float64(u.MaxBytes)
+	}
+	if u.MaxObjects > 0 {
+		if r := float64(u.UsedObjects) / float64(u.MaxObjects); r > result {
+			result = r
+		}
+	}
+	return result
+}
+
+// quotaNotification is the payload of the webhook, the fingerprint identifies the user or
+// bucket for deduplication by the receiver.
+type quotaNotification struct {
+	Status      string    `json:"status"`
+	Cluster     string    `json:"cluster,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	Threshold   float64   `json:"threshold"`
+	Utilization float64   `json:"utilization"`
+	Time        time.Time `json:"time"`
+	quotaUtilization
+}
+
+// quotaNotifyState has the thresholds delivered to the webhook for the users and buckets
+// by the fingerprints, and the last notifications queued but not delivered yet, which are
+// compared with the utilization instead until delivered. The last utilization is kept to
+// resolve the ones which no longer exist. The queue is sent by the running notifier of the
+// endpoint.
+type quotaNotifyState struct {
+	mtx     sync.Mutex
+	levels  map[string]float64
+	pending map[string]*quotaNotification
+	last    map[string]quotaUtilization
+	queue   chan *quotaNotification
+}
+
+// quotaNotifyStateFor - get the shared state of the radosgw endpoint
+func quotaNotifyStateFor(endpoint string) *quotaNotifyState {
+	return endpointState("quota_notify", endpoint, func() interface{} {
+		return &quotaNotifyState{
+			levels:  make(map[string]float64),
+			pending: make(map[string]*quotaNotification),
+			last:    make(map[string]quotaUtilization),
+			queue:   make(chan *quotaNotification, notificationQueueSize),
+		}
+	}).(*quotaNotifyState)
+}
+
+// notified - get the threshold notified for the fingerprint, the queued notification
+// counts as notified until it fails
+func (s *quotaNotifyState) notified(fingerprint string) float64 {
+	if notification, ok := s.pending[fingerprint]; ok {
+		if notification.Status == notificationResolved {
+			return 0
+		}
+		return notification.Threshold
+	}
+	return s.levels[fingerprint]
+}
+
+// done - record the threshold of the delivered notification, or forget the failed one so
+// that it is queued again by the next evaluation
+func (s *quotaNotifyState) done(notification *quotaNotification, delivered bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	fingerprint := notification.Fingerprint
+	if delivered {
+		if notification.Status == notificationResolved {
+			delete(s.levels, fingerprint)
+		} else {
+			s.levels[fingerprint] = notification.Threshold
+		}
+	}
+	if s.pending[fingerprint] == notification {
+		delete(s.pending, fingerprint)
+	}
+}
+
+// quotaNotifier evaluates the quota utilization after each collection of the quota
+// collector, and notifies the webhook when a user or bucket crosses a higher threshold,
+// or resolves when it falls below the lowest one. It only notifies once for a threshold
+// delivered, and the notifications are sent in background with retries.
+type quotaNotifier struct {
+	cluster  string
+	config   *QuotaNotificationsConfig
+	template *template.Template
+	logger   *slog.Logger
+	http     *http.Client
+	state    *quotaNotifyState
+
+	// notifications counts the sent notifications by status.
+	notifications *prometheus.CounterVec
+
+	// errors counts the notifications failed after all retries or dropped.
+	errors prometheus.Counter
+}
+
+func newQuotaNotifier(endpoint, cluster string, config *QuotaNotificationsConfig,
+	logger *slog.Logger) (*quotaNotifier, error) {
+	n := &quotaNotifier{
+		cluster: cluster,
+		config:  config,
+		logger:  logger.With("endpoint", endpoint),
+		http:    &http.Client{Timeout: notificationTimeout},
+		state:   quotaNotifyStateFor(endpoint),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "quota_notifications_total",
+			Help:      "total number of the sent quota notifications by status",
+		}, []string{"status"}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "quota_notification_errors_total",
+			Help:      "total number of the quota notifications failed after all retries or dropped",
+		}),
+	}
+	if len(config.Template) != 0 {
+		var err error
+		if n.template, err = template.New("quota").Parse(config.Template); err != nil {
+			return nil, fmt.Errorf("quota_notifications: invalid template: %v", err)
+		}
+	}
+	return n, nil
+}
+
+func (n *quotaNotifier) Describe(ch chan<- *prometheus.Desc) {
+	n.notifications.Describe(ch)
+	n.errors.Describe(ch)
+}
+
+func (n *quotaNotifier) Collect(ch chan<- prometheus.Metric) {
+	n.notifications.Collect(ch)
+	n.errors.Collect(ch)
+}
+
+// evaluate - compare the utilization with the thresholds and queue the notifications, the
+// users and buckets not given are resolved. It is a no-op for the nil notifier.
+func (n *quotaNotifier) evaluate(utilizations []quotaUtilization, now time.Time) {
+	if n == nil {
+		return
+	}
+	state := n.state
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	seen := make(map[string]bool, len(utilizations))
+	for _, u := range utilizations {
+		fingerprint := u.Kind + ":" + u.User
+		if len(u.Bucket) != 0 {
+			fingerprint = u.Kind + ":" + u.Bucket
+		}
+		seen[fingerprint] = true
+		state.last[fingerprint] = u
+		ratio := u.ratio()
+		level := 0.0
+		for _, threshold := range n.config.Thresholds {
+			if ratio >= threshold && threshold > level {
+				level = threshold
+			}
+		}
+		notified := state.notified(fingerprint)
+		switch {
+		case level > notified:
+			n.enqueue(notificationFiring, fingerprint, level, ratio, u, now)
+		case level == 0 && notified > 0:
+			n.enqueue(notificationResolved, fingerprint, notified, ratio, u, now)
+		case level < notified && state.pending[fingerprint] == nil:
+			// Falling between the thresholds fires again when crossing the higher one
+			state.levels[fingerprint] = level
+		}
+	}
+	for fingerprint, u := range state.last {
+		if seen[fingerprint] {
+			continue
+		}
+		// The last utilization is kept until the resolved notification is delivered
+		if notified := state.notified(fingerprint); notified > 0 {
+			n.enqueue(notificationResolved, fingerprint, notified, 0, u, now)
+		} else if _, ok := state.pending[fingerprint]; !ok {
+			delete(state.last, fingerprint)
+		}
+	}
+}
+
+// enqueue - queue the notification as pending, the dropped one is queued again by the
+// next evaluation. The state should be locked.
+func (n *quotaNotifier) enqueue(status, fingerprint string, threshold, ratio float64,
+	u quotaUtilization, now time.Time) {
+	notification := &quotaNotification{
+		Status:           status,
+		Cluster:          n.cluster,
+		Fingerprint:      fingerprint,
+		Threshold:        threshold,
+		Utilization:      ratio,
+		Time:             now.UTC(),
+		quotaUtilization: u,
+	}
+	select {
+	case n.state.queue <- notification:
+		n.state.pending[fingerprint] = notification
+	default:
+		n.errors.Inc()
+		n.logger.Error("drop the quota notification as the queue is full", "fingerprint", fingerprint)
+	}
+}
+
+// Run - send the queued notifications until the stop channel is closed, the ones not sent
+// yet are left in the queue for the notifier replacing it by the configuration reload
+func (n *quotaNotifier) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case notification := <-n.state.queue:
+			n.send(notification, stop)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// send - post the notification to the webhook, and retry with the doubled backoff
+func (n *quotaNotifier) send(notification *quotaNotification, stop <-chan struct{}) {
+	body := &bytes.Buffer{}
+	var err error
+	if n.template != nil {
+		err = n.template.Execute(body, notification)
+	} else {
+		err = json.NewEncoder(body).Encode(notification)
+	}
+	if err != nil {
+		n.errors.Inc()
+		n.logger.Error("render the quota notification failed", "err", err)
+		n.state.done(notification, false)
+		return
+	}
+
+	retries, backoff := n.config.MaxRetries, n.config.InitialBackoff
+	if retries == 0 {
+		retries = defaultNotificationRetries
+	}
+	if backoff == 0 {
+		backoff = defaultNotificationBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		if err = n.post(body.Bytes()); err == nil {
+			n.state.done(notification, true)
+			n.notifications.WithLabelValues(notification.Status).Inc()
+			n.logger.Info("send the quota notification succeeded", "status", notification.Status,
+				"fingerprint", notification.Fingerprint, "threshold", notification.Threshold)
+			return
+		}
+		if attempt >= retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-stop:
+			n.requeue(notification)
+			return
+		}
+	}
+	n.errors.Inc()
+	n.logger.Error("send the quota notification failed", "fingerprint", notification.Fingerprint,
+		"retries", retries, "err", err)
+	n.state.done(notification, false)
+}
+
+// requeue - put back the notification interrupted by stopping
+func (n *quotaNotifier) requeue(notification *quotaNotification) {
+	select {
+	case n.state.queue <- notification:
+	default:
+		n.errors.Inc()
+		n.logger.Error("drop the quota notification as the queue is full",
+			"fingerprint", notification.Fingerprint)
+		n.state.done(notification, false)
+	}
+}
+
+func (n *quotaNotifier) post(body []byte) error {
+	contentType := n.config.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/json"
+		if n.template != nil {
+			contentType = "text/plain; charset=utf-8"
+		}
+	}
+	resp, err := n.http.Post(n.config.WebhookURL, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d of the webhook", resp.StatusCode)
+	}
+	return nil
+}
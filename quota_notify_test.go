@@ -0,0 +1,173 @@
+// quota_notify_test.go - test the webhook notifications of the quota utilization
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWebhook records the notifications posted to it, and responds with the status.
+type testWebhook struct {
+	server *httptest.Server
+
+	mtx          sync.Mutex
+	status       int
+	bodies       []string
+	contentTypes []string
+}
+
+func newTestWebhook(t *testing.T) *testWebhook {
+	h := &testWebhook{status: http.StatusOK}
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if h.status < 300 {
+			h.bodies = append(h.bodies, string(body))
+			h.contentTypes = append(h.contentTypes, r.Header.Get("Content-Type"))
+		}
+		w.WriteHeader(h.status)
+	}))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *testWebhook) setStatus(status int) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.status = status
+}
+
+// delivered - get the status and threshold of the notifications delivered since last time
+func (h *testWebhook) delivered(t *testing.T) []string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	result := make([]string, 0, len(h.bodies))
+	for _, body := range h.bodies {
+		notification := &quotaNotification{}
+		if err := json.Unmarshal([]byte(body), notification); err != nil {
+			t.Fatalf("parse the notification %s failed: %v", body, err)
+		}
+		result = append(result, fmt.Sprintf("%s %v", notification.Status, notification.Threshold))
+	}
+	h.bodies = nil
+	return result
+}
+
+func newTestNotifier(t *testing.T, config *QuotaNotificationsConfig) *quotaNotifier {
+	config.Thresholds = []float64{0.8, 0.9}
+	config.MaxRetries, config.InitialBackoff = 1, time.Millisecond
+	n, err := newQuotaNotifier(t.Name(), "ceph-a", config, testLogger())
+	if err != nil {
+		t.Fatalf("create the notifier failed: %v", err)
+	}
+	return n
+}
+
+// drainNotifier - send the queued notifications
+func drainNotifier(n *quotaNotifier) {
+	for {
+		select {
+		case notification := <-n.state.queue:
+			n.send(notification, make(chan struct{}))
+		default:
+			return
+		}
+	}
+}
+
+func testUtilization(used int64) []quotaUtilization {
+	if used < 0 {
+		return nil
+	}
+	return []quotaUtilization{{Kind: "user", User: "alice", UsedBytes: used, MaxBytes: 100, MaxObjects: -1}}
+}
+
+func TestQuotaNotifierDedup(t *testing.T) {
+	steps := []struct {
+		name   string
+		used   int64
+		status int
+		want   []string
+	}{
+		{"below the thresholds", 50, http.StatusOK, nil},
+		{"crossed", 85, http.StatusOK, []string{"firing 0.8"}},
+		{"notified", 86, http.StatusOK, nil},
+		{"crossed higher", 95, http.StatusOK, []string{"firing 0.9"}},
+		{"fell between", 85, http.StatusOK, nil},
+		{"crossed higher again", 95, http.StatusOK, []string{"firing 0.9"}},
+		{"fell below", 10, http.StatusOK, []string{"resolved 0.9"}},
+		{"resolved", 10, http.StatusOK, nil},
+		{"failed", 85, http.StatusInternalServerError, nil},
+		{"sent again", 85, http.StatusOK, []string{"firing 0.8"}},
+		{"resolve failed", -1, http.StatusInternalServerError, nil},
+		{"resolved again", -1, http.StatusOK, []string{"resolved 0.8"}},
+		{"gone", -1, http.StatusOK, nil},
+	}
+	webhook := newTestWebhook(t)
+	n := newTestNotifier(t, &QuotaNotificationsConfig{WebhookURL: webhook.server.URL})
+	for _, step := range steps {
+		webhook.setStatus(step.status)
+		n.evaluate(testUtilization(step.used), time.Now())
+		drainNotifier(n)
+		if got := webhook.delivered(t); fmt.Sprint(got) != fmt.Sprint(step.want) {
+			t.Errorf("%s: got notifications %v, want %v", step.name, got, step.want)
+		}
+	}
+	if len(n.state.last) != 0 || len(n.state.levels) != 0 || len(n.state.pending) != 0 {
+		t.Errorf("got the state of the gone user kept")
+	}
+}
+
+func TestQuotaNotifierDropped(t *testing.T) {
+	webhook := newTestWebhook(t)
+	n := newTestNotifier(t, &QuotaNotificationsConfig{WebhookURL: webhook.server.URL})
+
+	// The notification dropped by the full queue is queued again by the next evaluation
+	queue := n.state.queue
+	n.state.queue = make(chan *quotaNotification)
+	n.evaluate(testUtilization(85), time.Now())
+	n.state.queue = queue
+	n.evaluate(testUtilization(85), time.Now())
+	n.evaluate(testUtilization(85), time.Now())
+	drainNotifier(n)
+	if got := webhook.delivered(t); fmt.Sprint(got) != "[firing 0.8]" {
+		t.Errorf("got notifications %v, want the firing one once", got)
+	}
+}
+
+func TestQuotaNotifierContentType(t *testing.T) {
+	cases := []struct {
+		name   string
+		config QuotaNotificationsConfig
+		want   string
+	}{
+		{"json", QuotaNotificationsConfig{}, "application/json"},
+		{"template", QuotaNotificationsConfig{Template: "{{.User}} is {{.Status}}"}, "text/plain; charset=utf-8"},
+		{"configured", QuotaNotificationsConfig{Template: `{"text": "{{.User}}"}`, ContentType: "application/json"},
+			"application/json"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			webhook := newTestWebhook(t)
+			c.config.WebhookURL = webhook.server.URL
+			n := newTestNotifier(t, &c.config)
+			n.evaluate(testUtilization(85), time.Now())
+			drainNotifier(n)
+			if len(webhook.contentTypes) != 1 || webhook.contentTypes[0] != c.want {
+				t.Errorf("got content types %v, want %s", webhook.contentTypes, c.want)
+			}
+			if len(c.config.Template) != 0 && !strings.Contains(webhook.bodies[0], "alice") {
+				t.Errorf("got body %s not rendered by the template", webhook.bodies[0])
+			}
+		})
+	}
+}
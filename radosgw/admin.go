@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 )
@@ -18,26 +20,108 @@ const (
 
 var globalHttpClient = &http.Client{Timeout: time.Second * 300}
 
+// redactedHeaders are not written to the debug logs as they contain the signature
+var redactedHeaders = []string{"Authorization", "X-Amz-Security-Token"}
+
+// RequestObserver is called after each request with the http method, the request uri,
+// the response status code and the elapsed time of the request
+type RequestObserver func(method, uri string, status int, elapsed time.Duration)
+
+// CredentialsError is returned if the credentials of a request failed to retrieve, the
+// request is not sent to the radosgw service then
+type CredentialsError struct {
+	Err error
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("retrieve credentials failed: %v", e.Err)
+}
+
+func (e *CredentialsError) Unwrap() error { return e.Err }
+
 // Client stands for the client to administrate the radosgw service
 type Client struct {
-	accessKeyId     string
-	secretAccessKey string
-	endpoint        string
-	prefix          string
+	credentials CredentialsProvider
+	endpoint    string
+	prefix      string
+	observer    RequestObserver
+	logger      *slog.Logger
+
+	// tenant qualifies the user ids and bucket names given to the methods.
+	tenant string
 }
 
 func NewClient(endpoint, ak, sk string) (*Client, error) {
-	if len(endpoint) == 0 || len(ak) == 0 || len(sk) == 0 {
+	if len(ak) == 0 || len(sk) == 0 {
 		return nil, fmt.Errorf("endpoint, ak and sk should not be empty")
 	}
+	return NewClientWithProvider(endpoint, NewStaticProvider(ak, sk))
+}
+
+// NewClientWithProvider - create the client which retrieves the credentials from the
+// provider before sending each request
+func NewClientWithProvider(endpoint string, provider CredentialsProvider) (*Client, error) {
+	if len(endpoint) == 0 || provider == nil {
+		return nil, fmt.Errorf("endpoint and credentials provider should not be empty")
+	}
 	if strings.HasSuffix(endpoint, "/") {
 		endpoint = endpoint[:len(endpoint)-1]
 	}
-	return &Client{ak, sk, endpoint, defaultAdminPrefix}, nil
+	return &Client{
+		credentials: provider,
+		endpoint:    endpoint,
+		prefix:      defaultAdminPrefix,
+	}, nil
 }
 
 func (c *Client) SetPrefix(p string) { c.prefix = p }
 
+func (c *Client) SetRequestObserver(o RequestObserver) { c.observer = o }
+
+// SetLogger - set the logger to write the requests and responses at debug level, the
+// signature in the headers is redacted
+func (c *Client) SetLogger(l *slog.Logger) { c.logger = l }
+
+func (c *Client) Endpoint() string { return c.endpoint }
+
+// AccessKey - get the access key id of the current credentials of the client
+//
+// RETURN:
+//     - string: the access key id identifying the admin user of the client
+//     - error: the credentials failed to retrieve
+func (c *Client) AccessKey() (string, error) {
+	cred, err := c.credentials.Retrieve()
+	if err != nil {
+		return "", &CredentialsError{err}
+	}
+	return cred.AccessKeyId, nil
+}
+
+// logHeaders - get the log attribute of the http headers with the secrets redacted
+//
+// PARAMS:
+//     - header: the http headers to be logged
+// RETURN:
+//     - slog.Attr: the attribute of the headers group
+func logHeaders(header http.Header) slog.Attr {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		value := header.Get(k)
+		for _, r := range redactedHeaders {
+			if http.CanonicalHeaderKey(k) == r {
+				value = "<redacted>"
+			}
+		}
+		attrs = append(attrs, slog.String(k, value))
+	}
+	return slog.Group("headers", attrs...)
+}
+
 func (c *Client) sendRequest(method, uri string, args url.Values, headers map[string]string,
 	body io.ReadCloser) (respBody []byte, status int, err error) {
 	// Create http request and set the input params
@@ -69,17 +153,40 @@ func (c *Client) sendRequest(method, uri string, args url.Values, headers map[st
 	}
 
 	// Calculate the authorization string for AWS4 request to s3 service
-	req = Sign(req, c.accessKeyId, c.secretAccessKey)
+	cred, err := c.credentials.Retrieve()
+	if err != nil {
+		return nil, 0, &CredentialsError{err}
+	}
+	req = Sign(req, cred.AccessKeyId, cred.SecretAccessKey)
+	if c.logger != nil {
+		c.logger.Debug("send request", "method", method, "url", requestUrl, logHeaders(req.Header))
+	}
 
 	// Do send the http request and get the result
+	begin := time.Now()
 	resp, err := globalHttpClient.Do(req)
 	if err != nil {
+		if c.observer != nil {
+			c.observer(method, uri, http.StatusInternalServerError, time.Since(begin))
+		}
+		if c.logger != nil {
+			c.logger.Debug("send request failed", "method", method, "url", requestUrl,
+				"duration", time.Since(begin), "err", err)
+		}
 		return nil, http.StatusInternalServerError, err
 	}
+	if c.observer != nil {
+		defer func() { c.observer(method, uri, status, time.Since(begin)) }()
+	}
 	if resp.Body != nil {
 		defer resp.Body.Close()
 	}
 	respBody, err = ioutil.ReadAll(resp.Body)
 	status = resp.StatusCode
+	if c.logger != nil {
+		// The body is not logged as it may contain the secret keys of the users
+		c.logger.Debug("receive response", "method", method, "url", requestUrl, "status", status,
+			"bytes", len(respBody), "duration", time.Since(begin), logHeaders(resp.Header))
+	}
 	return
 }
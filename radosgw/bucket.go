@@ -9,10 +9,30 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 )
 
+// Usage categories of the bucket stats
+const (
+	BucketUsageMain      = "rgw.main"
+	BucketUsageMultimeta = "rgw.multimeta"
+	BucketUsageNone      = "rgw.none"
+)
+
+// timeFormats are the formats of the time fields in the bucket stats, which differ
+// between the radosgw versions
+var timeFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999"}
+
+type BucketUsageCategoryType struct {
+	Size         int64 `json:"size"`
+	SizeActual   int64 `json:"size_actual"`
+	SizeUtilized int64 `json:"size_utilized"`
+	NumObjects   int64 `json:"num_objects"`
+}
+
 type BucketStatsType struct {
 	Bucket        string `json:"bucket"`
+	Tenant        string `json:"tenant"`
 	Zonegroup     string `json:"zonegroup"`
 	PlacementRule string `json:"placement_rule"`
 	ID            string `json:"id"`
@@ -22,15 +42,28 @@ type BucketStatsType struct {
 	Ver           string `json:"ver"`
 	MasterVer     string `json:"master_ver"`
 	Mtime         string `json:"mtime"`
+	CreationTime  string `json:"creation_time"`
 	MaxMarker     string `json:"max_marker"`
-	Usage         struct {
-		RgwMain struct {
-			NumObjects int64 `json:"num_objects"`
-			Size       int64 `json:"size"`
-			SizeActual int64 `json:"size_actual"`
-		} `json:"rgw.main"`
-	} `json:"usage"`
-	BucketQuota QuotaType `json:"bucket_quota"`
+
+	// Usage maps the categories such as rgw.main, rgw.multimeta and rgw.none to the usage.
+	Usage       map[string]BucketUsageCategoryType `json:"usage"`
+	BucketQuota QuotaType                          `json:"bucket_quota"`
+}
+
+// ParseTime - parse the time field of the radosgw responses such as mtime
+//
+// PARAMS:
+//     - value: the time string
+// RETURN:
+//     - time.Time: the parsed time
+//     - error: the error if the time string has an unknown format
+func ParseTime(value string) (time.Time, error) {
+	for _, format := range timeFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format: %s", value)
 }
 
 type BucketType struct {
@@ -52,16 +85,16 @@ func (c *Client) GetBucket(bucket, uid string, stats bool) (int, []BucketType, e
 	args := url.Values{}
 	args.Add("format", "json")
 	if len(bucket) != 0 {
-		args.Add("bucket", bucket)
+		args.Add("bucket", c.bucketName(bucket))
 	}
 	if len(uid) != 0 {
-		args.Add("uid", uid)
+		args.Add("uid", c.userId(uid))
 	}
 	args.Add("stats", fmt.Sprintf("%v", stats))
 
 	body, status, err := c.sendRequest("GET", "/bucket", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -112,14 +145,14 @@ func (c *Client) GetPolicy(bucket, object string) (int, []byte, error) {
 	if len(bucket) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("bucket name should not be empty")
 	}
-	args.Add("bucket", bucket)
+	args.Add("bucket", c.bucketName(bucket))
 	if len(object) != 0 {
 		args.Add("object", object)
 	}
 
 	body, status, err := c.sendRequest("GET", "/bucket", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -141,12 +174,12 @@ func (c *Client) DeleteBucket(bucket string, purgeObjects bool) (int, error) {
 	if len(bucket) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("bucket name should not be empty")
 	}
-	args.Add("bucket", bucket)
+	args.Add("bucket", c.bucketName(bucket))
 	args.Add("purge-objects", fmt.Sprintf("%v", purgeObjects))
 
 	body, status, err := c.sendRequest("DELETE", "/bucket", args, nil, nil)
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
@@ -174,7 +207,7 @@ func (c *Client) DeleteObject(bucket, object string) (int, error) {
 	c.SetPrefix("")
 	body, status, err := c.sendRequest("DELETE", uri, nil, nil, nil)
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
@@ -197,7 +230,7 @@ func (c *Client) LinkBucket(bucket, bucketId, uid string) (int, error) {
 	if len(bucket) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("bucket name should not be empty")
 	}
-	args.Add("bucket", bucket)
+	args.Add("bucket", c.bucketName(bucket))
 	if len(bucketId) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("bucket id should not be empty")
 	}
@@ -205,11 +238,11 @@ func (c *Client) LinkBucket(bucket, bucketId, uid string) (int, error) {
 	if len(uid) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 
 	body, status, err := c.sendRequest("PUT", "/bucket", args, nil, nil)
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
@@ -231,15 +264,15 @@ func (c *Client) UnlinkBucket(bucket, uid string) (int, error) {
 	if len(bucket) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("bucket name should not be empty")
 	}
-	args.Add("bucket", bucket)
+	args.Add("bucket", c.bucketName(bucket))
 	if len(uid) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 
 	body, status, err := c.sendRequest("POST", "/bucket", args, nil, nil)
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
@@ -278,7 +311,7 @@ func (c *Client) CreateBucket(bucket, region, acl string) (int, error) {
 	body, status, err := c.sendRequest(
 		"PUT", "/"+bucket, nil, aclHeader, ioutil.NopCloser(inputBody))
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
@@ -0,0 +1,210 @@
+//credentials.go - defines the credentials providers to sign the requests
+
+package radosgw
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	defaultSecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+	defaultProfile      = "default"
+)
+
+// Credentials stands for the AK/SK pair to sign the requests
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+// CredentialsProvider provides the credentials before sending each request, so that the
+// rotated secrets are used without restarting
+type CredentialsProvider interface {
+	Retrieve() (Credentials, error)
+}
+
+// StaticProvider provides the fixed credentials
+type StaticProvider struct {
+	Credentials
+}
+
+func NewStaticProvider(ak, sk string) *StaticProvider {
+	return &StaticProvider{Credentials{ak, sk}}
+}
+
+func (p *StaticProvider) Retrieve() (Credentials, error) {
+	if len(p.AccessKeyId) == 0 || len(p.SecretAccessKey) == 0 {
+		return Credentials{}, fmt.Errorf("ak and sk should not be empty")
+	}
+	return p.Credentials, nil
+}
+
+// EnvProvider provides the credentials from the environment variables
+type EnvProvider struct {
+	AccessKeyEnv string
+	SecretKeyEnv string
+}
+
+// NewEnvProvider - create the provider reading the given environment variables, the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used if they are empty
+func NewEnvProvider(akEnv, skEnv string) *EnvProvider {
+	if len(akEnv) == 0 {
+		akEnv = defaultAccessKeyEnv
+	}
+	if len(skEnv) == 0 {
+		skEnv = defaultSecretKeyEnv
+	}
+	return &EnvProvider{akEnv, skEnv}
+}
+
+func (p *EnvProvider) Retrieve() (Credentials, error) {
+	result := Credentials{os.Getenv(p.AccessKeyEnv), os.Getenv(p.SecretKeyEnv)}
+	if len(result.AccessKeyId) == 0 || len(result.SecretAccessKey) == 0 {
+		return Credentials{}, fmt.Errorf("environment variable %s or %s is empty",
+			p.AccessKeyEnv, p.SecretKeyEnv)
+	}
+	return result, nil
+}
+
+// watchedFile caches the content of a file and reads it again once it is changed
+type watchedFile struct {
+	name    string
+	modTime time.Time
+	size    int64
+	content []byte
+}
+
+func (f *watchedFile) read() ([]byte, error) {
+	info, err := os.Stat(f.name)
+	if err != nil {
+		return nil, err
+	}
+	if f.content != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.content, nil
+	}
+	content, err := ioutil.ReadFile(f.name)
+	if err != nil {
+		return nil, err
+	}
+	f.modTime, f.size, f.content = info.ModTime(), info.Size(), content
+	return content, nil
+}
+
+// FileProvider provides the credentials from two files which only contain the AK and SK,
+// such as the secrets mounted by kubernetes or docker. The files are read again once
+// they are changed.
+type FileProvider struct {
+	mtx           sync.Mutex
+	accessKeyFile watchedFile
+	secretKeyFile watchedFile
+}
+
+func NewFileProvider(akFile, skFile string) *FileProvider {
+	return &FileProvider{
+		accessKeyFile: watchedFile{name: akFile},
+		secretKeyFile: watchedFile{name: skFile},
+	}
+}
+
+func (p *FileProvider) Retrieve() (Credentials, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	ak, err := p.accessKeyFile.read()
+	if err != nil {
+		return Credentials{}, err
+	}
+	sk, err := p.secretKeyFile.read()
+	if err != nil {
+		return Credentials{}, err
+	}
+	result := Credentials{strings.TrimSpace(string(ak)), strings.TrimSpace(string(sk))}
+	if len(result.AccessKeyId) == 0 || len(result.SecretAccessKey) == 0 {
+		return Credentials{}, fmt.Errorf("file %s or %s is empty",
+			p.accessKeyFile.name, p.secretKeyFile.name)
+	}
+	return result, nil
+}
+
+// SharedCredentialsProvider provides the credentials of a profile in the AWS shared
+// credentials file. The file is read again once it is changed.
+type SharedCredentialsProvider struct {
+	profile string
+
+	mtx  sync.Mutex
+	file watchedFile
+}
+
+// NewSharedCredentialsProvider - create the provider reading the AWS shared credentials file
+//
+// PARAMS:
+//     - filename: the credentials file, defaults to $AWS_SHARED_CREDENTIALS_FILE or
+//       ~/.aws/credentials if it is empty
+//     - profile: the profile name, defaults to $AWS_PROFILE or "default" if it is empty
+// RETURN:
+//     - *SharedCredentialsProvider: the created provider
+func NewSharedCredentialsProvider(filename, profile string) *SharedCredentialsProvider {
+	if len(filename) == 0 {
+		filename = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if len(filename) == 0 {
+		home, _ := os.UserHomeDir()
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+	if len(profile) == 0 {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if len(profile) == 0 {
+		profile = defaultProfile
+	}
+	return &SharedCredentialsProvider{profile: profile, file: watchedFile{name: filename}}
+}
+
+func (p *SharedCredentialsProvider) Retrieve() (Credentials, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	content, err := p.file.read()
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	// Parse the INI format file and find the keys in the section of the profile
+	result := Credentials{}
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != p.profile {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			result.AccessKeyId = strings.TrimSpace(kv[1])
+		case "aws_secret_access_key":
+			result.SecretAccessKey = strings.TrimSpace(kv[1])
+		}
+	}
+	if len(result.AccessKeyId) == 0 || len(result.SecretAccessKey) == 0 {
+		return Credentials{}, fmt.Errorf("no credentials of profile %s in %s", p.profile, p.file.name)
+	}
+	return result, nil
+}
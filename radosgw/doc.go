@@ -8,6 +8,8 @@
 // One can use any other APIs that supported by the package:
 //  - user management
 //    GetUser(uid ...string) (int, *UserType, error)
+//    GetUserWithStats(uid string) (int, *UserType, error)
+//    ListUsers() (int, []string, error)
 //    CreateUser(uid, dispName, email string, maxBuckets int) (int, *UserType, error)
 //    UpdateUser(uid, displayName, email string,
 //        maxBuckets int, suspended bool) (int, *UserType, error)
@@ -31,6 +33,16 @@
 //        showSummary, showEntries bool) (int, *UsageType, error)
 //    DeleteUsage(uid string, start, end *time.Time, deleteAll bool) (int, error)
 //
+// The credentials can also be provided by a CredentialsProvider, which is asked before
+// sending each request so that the rotated secrets are used without creating a new client:
+//     client, _ := radosgw.NewClientWithProvider({endpoint}, radosgw.NewFileProvider({akFile}, {skFile}))
+// The StaticProvider, EnvProvider, FileProvider and SharedCredentialsProvider are supported.
+//
+// The users and buckets in a tenant are named as "tenant$user" and "tenant/bucket", the
+// client returned by WithTenant qualifies the user ids and bucket names by the tenant:
+//     client.WithTenant({tenant}).GetUser({uid})
+// The SplitUserId and SplitBucketName split the qualified names into the tenant and name.
+//
 // All admin OP API performs the http request to the given radosgw service using the AWS
 // S3(v4) signature method. The status code and raw bytes body of http response are all
 // directly returned to the caller allowing you to define custom post-process strategies.
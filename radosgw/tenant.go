@@ -0,0 +1,93 @@
+//tenant.go - implements the tenant qualified user ids and bucket names
+
+package radosgw
+
+import "strings"
+
+const (
+	tenantUserSeparator   = "$"
+	tenantBucketSeparator = "/"
+)
+
+// JoinUserId - get the user id qualified by the tenant
+//
+// PARAMS:
+//     - tenant: the tenant name, the user id is not qualified if it is empty
+//     - user: the user name in the tenant
+// RETURN:
+//     - string: the user id in the "tenant$user" format
+func JoinUserId(tenant, user string) string {
+	if len(tenant) == 0 {
+		return user
+	}
+	return tenant + tenantUserSeparator + user
+}
+
+// SplitUserId - split the user id in the "tenant$user" format
+//
+// PARAMS:
+//     - uid: the user id string
+// RETURN:
+//     - string: the tenant name, empty if the user is not in a tenant
+//     - string: the user name in the tenant
+func SplitUserId(uid string) (string, string) {
+	if i := strings.Index(uid, tenantUserSeparator); i >= 0 {
+		return uid[:i], uid[i+1:]
+	}
+	return "", uid
+}
+
+// JoinBucketName - get the bucket name qualified by the tenant
+//
+// PARAMS:
+//     - tenant: the tenant name, the bucket name is not qualified if it is empty
+//     - bucket: the bucket name in the tenant
+// RETURN:
+//     - string: the bucket name in the "tenant/bucket" format
+func JoinBucketName(tenant, bucket string) string {
+	if len(tenant) == 0 {
+		return bucket
+	}
+	return tenant + tenantBucketSeparator + bucket
+}
+
+// SplitBucketName - split the bucket name in the "tenant/bucket" format
+//
+// PARAMS:
+//     - name: the bucket name string
+// RETURN:
+//     - string: the tenant name, empty if the bucket is not in a tenant
+//     - string: the bucket name in the tenant
+func SplitBucketName(name string) (string, string) {
+	if i := strings.Index(name, tenantBucketSeparator); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// WithTenant - get a copy of the client in which the user ids and bucket names given to
+// all methods are qualified by the tenant unless they are already qualified
+//
+// PARAMS:
+//     - tenant: the tenant name
+// RETURN:
+//     - *Client: the client of the tenant
+func (c *Client) WithTenant(tenant string) *Client {
+	result := *c
+	result.tenant = tenant
+	return &result
+}
+
+func (c *Client) userId(uid string) string {
+	if len(uid) == 0 || strings.Contains(uid, tenantUserSeparator) {
+		return uid
+	}
+	return JoinUserId(c.tenant, uid)
+}
+
+func (c *Client) bucketName(bucket string) string {
+	if len(bucket) == 0 || strings.Contains(bucket, tenantBucketSeparator) {
+		return bucket
+	}
+	return JoinBucketName(c.tenant, bucket)
+}
@@ -0,0 +1,82 @@
+//tenant_test.go - test the tenant qualified user ids and bucket names
+
+package radosgw
+
+import "testing"
+
+func TestUserId(t *testing.T) {
+	cases := []struct {
+		uid    string
+		tenant string
+		user   string
+	}{
+		{"alice", "", "alice"},
+		{"acme$alice", "acme", "alice"},
+		{"acme$", "acme", ""},
+		{"$alice", "", "alice"},
+	}
+	for _, c := range cases {
+		tenant, user := SplitUserId(c.uid)
+		if tenant != c.tenant || user != c.user {
+			t.Errorf("got %q and %q split from %q, want %q and %q", tenant, user, c.uid, c.tenant, c.user)
+		}
+		if len(c.tenant) != 0 || c.uid == c.user {
+			if uid := JoinUserId(tenant, user); uid != c.uid {
+				t.Errorf("got %q joined from %q and %q, want %q", uid, tenant, user, c.uid)
+			}
+		}
+	}
+}
+
+func TestBucketName(t *testing.T) {
+	cases := []struct {
+		name   string
+		tenant string
+		bucket string
+	}{
+		{"b1", "", "b1"},
+		{"acme/b1", "acme", "b1"},
+		{"acme/b1/x", "acme", "b1/x"},
+	}
+	for _, c := range cases {
+		tenant, bucket := SplitBucketName(c.name)
+		if tenant != c.tenant || bucket != c.bucket {
+			t.Errorf("got %q and %q split from %q, want %q and %q", tenant, bucket, c.name, c.tenant, c.bucket)
+		}
+		if name := JoinBucketName(tenant, bucket); name != c.name {
+			t.Errorf("got %q joined from %q and %q, want %q", name, tenant, bucket, c.name)
+		}
+	}
+}
+
+func TestWithTenant(t *testing.T) {
+	client, err := NewClient("http://rgw", "ak", "sk")
+	if err != nil {
+		t.Fatalf("create the client failed: %v", err)
+	}
+	tenanted := client.WithTenant("acme")
+	cases := []struct {
+		client *Client
+		in     string
+		uid    string
+		bucket string
+	}{
+		{client, "alice", "alice", "alice"},
+		{tenanted, "alice", "acme$alice", "acme/alice"},
+		// The qualified ones are kept as they are
+		{tenanted, "other$alice", "other$alice", "acme/other$alice"},
+		{tenanted, "other/b1", "acme$other/b1", "other/b1"},
+		{tenanted, "", "", ""},
+	}
+	for _, c := range cases {
+		if uid := c.client.userId(c.in); uid != c.uid {
+			t.Errorf("got user id %q of %q, want %q", uid, c.in, c.uid)
+		}
+		if bucket := c.client.bucketName(c.in); bucket != c.bucket {
+			t.Errorf("got bucket %q of %q, want %q", bucket, c.in, c.bucket)
+		}
+	}
+	if len(client.tenant) != 0 {
+		t.Errorf("got the tenant set to the original client")
+	}
+}
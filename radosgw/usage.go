@@ -63,7 +63,7 @@ func (c *Client) GetUsage(uid string, start, end *time.Time,
 	args := url.Values{}
 	args.Add("format", "json")
 	if len(uid) != 0 {
-		args.Add("uid", uid)
+		args.Add("uid", c.userId(uid))
 	}
 	if start != nil {
 		args.Add("start", start.Format(usageTimeFormat))
@@ -76,7 +76,7 @@ func (c *Client) GetUsage(uid string, start, end *time.Time,
 
 	body, status, err := c.sendRequest("GET", "/usage", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -94,7 +94,7 @@ func (c *Client) GetUsage(uid string, start, end *time.Time,
 //     - uid: user id string
 //     - start: start timestamp
 //     - end: end timestamp, not include
-//     - deleteAll: delete all usage info, default is true
+//     - deleteAll: delete the usage info of all users, required if the uid is empty
 // RETURN:
 //     - int: the response status code
 //     - error: the request error
@@ -102,7 +102,7 @@ func (c *Client) DeleteUsage(uid string, start, end *time.Time, deleteAll bool)
 	args := url.Values{}
 	args.Add("format", "json")
 	if len(uid) != 0 {
-		args.Add("uid", uid)
+		args.Add("uid", c.userId(uid))
 	}
 	if start != nil {
 		args.Add("start", start.Format(usageTimeFormat))
@@ -110,11 +110,11 @@ func (c *Client) DeleteUsage(uid string, start, end *time.Time, deleteAll bool)
 	if end != nil {
 		args.Add("end", end.Format(usageTimeFormat))
 	}
-	args.Add("deleteAll", fmt.Sprintf("%v", deleteAll))
+	args.Add("remove-all", fmt.Sprintf("%v", deleteAll))
 
 	body, status, err := c.sendRequest("DELETE", "/usage", args, nil, nil)
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
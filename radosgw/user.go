@@ -27,14 +27,24 @@ type QuotaType struct {
 	Enabled    bool  `json:"enabled,omitempty"`
 }
 
+type UserStatsType struct {
+	Size       int64 `json:"size"`
+	SizeActual int64 `json:"size_actual"`
+	NumObjects int64 `json:"num_objects"`
+}
+
 type UserType struct {
-	UserID      string    `json:"user_id"`
-	DisplayName string    `json:"display_name"`
-	Email       string    `json:"email"`
-	Keys        []KeyType `json:"keys"`
-	Caps        []CapType `json:"caps"`
-	MaxBuckets  int64     `json:"max_buckets"`
-	Suspended   int       `json:"suspended"`
+	UserID      string         `json:"user_id"`
+	Tenant      string         `json:"tenant"`
+	DisplayName string         `json:"display_name"`
+	Email       string         `json:"email"`
+	Keys        []KeyType      `json:"keys"`
+	Caps        []CapType      `json:"caps"`
+	MaxBuckets  int64          `json:"max_buckets"`
+	Suspended   int            `json:"suspended"`
+	UserQuota   QuotaType      `json:"user_quota"`
+	BucketQuota QuotaType      `json:"bucket_quota"`
+	Stats       *UserStatsType `json:"stats,omitempty"`
 }
 
 // GetUser - get the user info by the specific uid
@@ -49,11 +59,11 @@ func (c *Client) GetUser(uid ...string) (int, *UserType, error) {
 	args := url.Values{}
 	args.Add("format", "json")
 	if len(uid) != 0 {
-		args.Add("uid", uid[0])
+		args.Add("uid", c.userId(uid[0]))
 	}
 	body, status, err := c.sendRequest("GET", "/user", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -65,6 +75,60 @@ func (c *Client) GetUser(uid ...string) (int, *UserType, error) {
 	return status, result, nil
 }
 
+// GetUserWithStats - get the user info with the storage stats by the specific uid
+//
+// PARAMS:
+//     - uid: user id string
+// RETURN:
+//     - int: the response status code
+//     - *UserType: the user infomation of the specific uid with the Stats field
+//     - error: the request error
+func (c *Client) GetUserWithStats(uid string) (int, *UserType, error) {
+	args := url.Values{}
+	args.Add("format", "json")
+	if len(uid) == 0 {
+		return http.StatusBadRequest, nil, fmt.Errorf("user id should not be empty")
+	}
+	args.Add("uid", c.userId(uid))
+	args.Add("stats", "true")
+	body, status, err := c.sendRequest("GET", "/user", args, nil, nil)
+	if err != nil {
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
+	}
+	if status >= 400 {
+		return status, nil, fmt.Errorf("%s", string(body))
+	}
+	result := &UserType{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return status, nil, err
+	}
+	return status, result, nil
+}
+
+// ListUsers - list the ids of all users by the metadata API, the users in tenants are
+// listed in the "tenant$user" format
+//
+// RETURN:
+//     - int: the response status code
+//     - []string: the user ids
+//     - error: the request error
+func (c *Client) ListUsers() (int, []string, error) {
+	args := url.Values{}
+	args.Add("format", "json")
+	body, status, err := c.sendRequest("GET", "/metadata/user", args, nil, nil)
+	if err != nil {
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
+	}
+	if status >= 400 {
+		return status, nil, fmt.Errorf("%s", string(body))
+	}
+	result := make([]string, 0)
+	if err := json.Unmarshal(body, &result); err != nil {
+		return status, nil, err
+	}
+	return status, result, nil
+}
+
 // CreateUser - create the radosgw user
 //
 // PARAMS:
@@ -82,7 +146,7 @@ func (c *Client) CreateUser(uid, dispName, email string, maxBuckets int64) (int,
 	if len(uid) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	if len(dispName) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("display name should not be empty")
 	}
@@ -96,7 +160,7 @@ func (c *Client) CreateUser(uid, dispName, email string, maxBuckets int64) (int,
 
 	body, status, err := c.sendRequest("PUT", "/user", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -127,7 +191,7 @@ func (c *Client) UpdateUser(uid, displayName, email string,
 	if len(uid) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	if len(displayName) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("display name should not be empty")
 	}
@@ -142,7 +206,7 @@ func (c *Client) UpdateUser(uid, displayName, email string,
 
 	body, status, err := c.sendRequest("POST", "/user", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -154,6 +218,37 @@ func (c *Client) UpdateUser(uid, displayName, email string,
 	return status, result, nil
 }
 
+// SuspendUser - suspend or resume the radosgw user, the other attributes are unchanged
+//
+// PARAMS:
+//     - uid: user id string
+//     - suspended: set the user suspended or not
+// RETURN:
+//     - int: the response status code
+//     - error: the request error
+func (c *Client) SuspendUser(uid string, suspended bool) (int, error) {
+	args := url.Values{}
+	args.Add("format", "json")
+	if len(uid) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("user id should not be empty")
+	}
+	args.Add("uid", c.userId(uid))
+	if suspended {
+		args.Add("suspended", "1")
+	} else {
+		args.Add("suspended", "0")
+	}
+
+	body, status, err := c.sendRequest("POST", "/user", args, nil, nil)
+	if err != nil {
+		return status, fmt.Errorf("%w: %s", err, string(body))
+	}
+	if status >= 400 {
+		return status, fmt.Errorf("%s", string(body))
+	}
+	return status, nil
+}
+
 // DeleteUser - delete the radosgw user by the user id
 //
 // PARAMS:
@@ -167,7 +262,7 @@ func (c *Client) DeleteUser(uid string, purgeData bool) (int, error) {
 	if len(uid) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	if purgeData {
 		args.Add("purge-data", "")
 	}
@@ -191,11 +286,11 @@ func (c *Client) CreateKey(uid string) (int, []KeyType, error) {
 	if len(uid) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 
 	body, status, err := c.sendRequest("PUT", "/user", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -221,7 +316,7 @@ func (c *Client) DeleteKey(uid, ak string) (int, error) {
 	if len(uid) == 0 || len(ak) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("user id or access key id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	args.Add("access-key", ak)
 
 	_, status, err := c.sendRequest("DELETE", "/user", args, nil, nil)
@@ -246,7 +341,7 @@ func (c *Client) AddCaps(uid string, user, buckets, usage []string) (int, []CapT
 	if len(uid) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	userCaps := make([]string, 0, 3)
 	if user != nil && len(user) != 0 {
 		userCaps = append(userCaps, fmt.Sprintf("user=%s", strings.Join(user, ",")))
@@ -261,7 +356,7 @@ func (c *Client) AddCaps(uid string, user, buckets, usage []string) (int, []CapT
 
 	body, status, err := c.sendRequest("PUT", "/user", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -289,7 +384,7 @@ func (c *Client) DeleteCaps(uid string, user, buckets, usage []string) (int, err
 	if len(uid) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	userCaps := make([]string, 0, 3)
 	if user != nil && len(user) != 0 {
 		userCaps = append(userCaps, fmt.Sprintf("user=%s", strings.Join(user, ",")))
@@ -322,7 +417,7 @@ func (c *Client) GetQuota(uid, quotaType string) (int, *QuotaType, error) {
 	if len(uid) == 0 {
 		return http.StatusBadRequest, nil, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	if quotaType != "user" && quotaType != "bucket" {
 		return http.StatusBadRequest, nil, fmt.Errorf("quota type is not valid")
 	}
@@ -330,7 +425,7 @@ func (c *Client) GetQuota(uid, quotaType string) (int, *QuotaType, error) {
 
 	body, status, err := c.sendRequest("GET", "/user", args, nil, nil)
 	if err != nil {
-		return status, nil, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, nil, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, nil, fmt.Errorf("%s", string(body))
@@ -362,7 +457,7 @@ func (c *Client) SetQuota(uid, quotaType string, maxObjects,
 	if len(uid) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("user id should not be empty")
 	}
-	args.Add("uid", uid)
+	args.Add("uid", c.userId(uid))
 	if quotaType != "user" && quotaType != "bucket" {
 		return http.StatusBadRequest, fmt.Errorf("quota type is not valid")
 	}
@@ -377,11 +472,11 @@ func (c *Client) SetQuota(uid, quotaType string, maxObjects,
 	if quotaType == "bucket" && len(bucketName) == 0 {
 		return http.StatusBadRequest, fmt.Errorf("bucket name is empty for bucket quota type")
 	}
-	args.Add("bucket", bucketName)
+	args.Add("bucket", c.bucketName(bucketName))
 
 	body, status, err := c.sendRequest("PUT", "/"+quotaType, args, nil, nil)
 	if err != nil {
-		return status, fmt.Errorf("%s: %s", err.Error(), string(body))
+		return status, fmt.Errorf("%w: %s", err, string(body))
 	}
 	if status >= 400 {
 		return status, fmt.Errorf("%s", string(body))
@@ -0,0 +1,445 @@
+// report.go - implement the chargeback reports generated from the usage data
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const (
+	reportFormatCSV      = "csv"
+	reportFormatJSON     = "json"
+	reportFormatMarkdown = "markdown"
+
+	bytesPerGB    = 1 << 30
+	hoursPerMonth = 730
+
+	// reportUncovered is the reason of the errors of the periods not covered by the usage
+	// log or the bucket size samples.
+	reportUncovered = "uncovered_period"
+
+	reportMonthFormat = "2006-01"
+	reportDateFormat  = "2006-01-02"
+)
+
+var (
+	reportPeriod = flag.String("report.period", "",
+		"period of the report command, a month such as 2026-09 or dates such as 2026-09-01/2026-09-15, the last month if empty")
+	reportFormat = flag.String("report.format", reportFormatCSV,
+		"output format of the report command, one of csv, json and markdown")
+	reportCluster = flag.String("report.cluster", "",
+		"cluster of the config file reported by the report command, may be omitted if there is only one")
+
+	errUnknownCluster = errors.New("unknown cluster")
+
+	reportContentTypes = map[string]string{
+		reportFormatCSV:      "text/csv; charset=utf-8",
+		reportFormatJSON:     "application/json",
+		reportFormatMarkdown: "text/markdown; charset=utf-8",
+	}
+)
+
+// parseReportPeriod - parse the period of a month or dates separated by a slash, the end
+// date is not included. The last month is used if the value is empty. The period should
+// not start in the future.
+func parseReportPeriod(value string, now time.Time) (start, end time.Time, err error) {
+	start, end, err = parsePeriod(value, now)
+	if err == nil && start.After(now) {
+		err = fmt.Errorf("the period %s starts in the future", value)
+	}
+	return start, end, err
+}
+
+func parsePeriod(value string, now time.Time) (start, end time.Time, err error) {
+	if len(value) == 0 {
+		end = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return end.AddDate(0, -1, 0), end, nil
+	}
+	if i := strings.Index(value, "/"); i >= 0 {
+		if start, err = time.Parse(reportDateFormat, value[:i]); err != nil {
+			return start, end, fmt.Errorf("invalid start date of the period %s", value)
+		}
+		if end, err = time.Parse(reportDateFormat, value[i+1:]); err != nil {
+			return start, end, fmt.Errorf("invalid end date of the period %s", value)
+		}
+		if !end.After(start) {
+			return start, end, fmt.Errorf("the end of the period %s should be after the start", value)
+		}
+		return start, end, nil
+	}
+	if start, err = time.Parse(reportMonthFormat, value); err != nil {
+		return start, end, fmt.Errorf("invalid period %s, should be a month or dates", value)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// reportLine is the cost of a user or a bucket in the chargeback report.
+type reportLine struct {
+	Tenant string `json:"tenant"`
+	User   string `json:"user"`
+	Bucket string `json:"bucket,omitempty"`
+
+	// StorageBytes is the average size of the objects in the period, or the size sampled
+	// when the report is generated if the period has not ended.
+	StorageBytes int64            `json:"storage_bytes"`
+	EgressBytes  int64            `json:"egress_bytes"`
+	Requests     map[string]int64 `json:"requests"`
+
+	StorageCost float64 `json:"storage_cost"`
+	RequestCost float64 `json:"request_cost"`
+	EgressCost  float64 `json:"egress_cost"`
+	TotalCost   float64 `json:"total_cost"`
+}
+
+func (l *reportLine) add(o *reportLine) {
+	l.StorageBytes += o.StorageBytes
+	l.EgressBytes += o.EgressBytes
+	for family, requests := range o.Requests {
+		l.Requests[family] += requests
+	}
+}
+
+func (l *reportLine) requests() int64 {
+	result := int64(0)
+	for _, requests := range l.Requests {
+		result += requests
+	}
+	return result
+}
+
+// price - compute the costs of the line by the price book for the months of the period
+func (l *reportLine) price(pricing *PricingConfig, months float64) {
+	l.StorageCost = float64(l.StorageBytes) / bytesPerGB * months * pricing.StorageGBMonth
+	l.EgressCost = float64(l.EgressBytes) / bytesPerGB * pricing.EgressGB
+	l.RequestCost = 0
+	for family, requests := range l.Requests {
+		l.RequestCost += float64(requests) / 1000 * pricing.Requests[family]
+	}
+	l.TotalCost = l.StorageCost + l.RequestCost + l.EgressCost
+}
+
+// chargebackReport has the costs of each user and bucket in the period.
+type chargebackReport struct {
+	Start            time.Time     `json:"start"`
+	End              time.Time     `json:"end"`
+	Currency         string        `json:"currency"`
+	StorageSampledAt *time.Time    `json:"storage_sampled_at,omitempty"`
+	Users            []*reportLine `json:"users"`
+	Buckets          []*reportLine `json:"buckets"`
+}
+
+// checkReportSamples - check the bucket size samples can cover the period if it has
+// ended, they are only taken by the growth collector and kept for its window. The hint
+// tells how to take them if they are not taken.
+func checkReportSamples(start, end, now time.Time, sampled bool, hint string) error {
+	if end.After(now) {
+		return nil
+	}
+	if !sampled {
+		return &scrapeError{reportUncovered, fmt.Errorf("the stored capacity of the ended period is "+
+			"averaged from the bucket size samples of the growth collector, %s", hint)}
+	}
+	if start.Before(now.Add(-*growthWindow)) {
+		return &scrapeError{reportUncovered, fmt.Errorf("the period starts before the "+
+			"-collector.growth.window %s of the bucket size samples", *growthWindow)}
+	}
+	return nil
+}
+
+// generateReport - generate the chargeback report of the period. The requests and egress
+// are summed up from the usage log entries in the period, which should not be trimmed
+// by the usage trimming yet. The stored capacity of an ended period is averaged from
+// the bucket size samples of the growth collector, while the one of the current period
+// is sampled from the bucket stats at once and billed for the elapsed part of it.
+func generateReport(client *radosgw.Client, pricing *PricingConfig, families opFamilies,
+	usageTrim *UsageTrimConfig, start, end, now time.Time) (*chargebackReport, error) {
+	if usageTrim != nil && start.Before(now.Add(-usageTrim.Retention)) {
+		return nil, &scrapeError{reportUncovered, fmt.Errorf("the period starts before the retention %s "+
+			"of the usage trimming, its usage log entries may have been trimmed", usageTrim.Retention)}
+	}
+	type lineKey struct{ tenant, user, bucket string }
+	lines := make(map[lineKey]*reportLine)
+	line := func(tenant, user, bucket string) *reportLine {
+		key := lineKey{tenant, user, bucket}
+		if l, ok := lines[key]; ok {
+			return l
+		}
+		l := &reportLine{Tenant: tenant, User: user, Bucket: bucket, Requests: make(map[string]int64)}
+		lines[key] = l
+		return l
+	}
+
+	status, usage, err := client.GetUsage("", &start, &end, false, true)
+	if err != nil || status > 200 {
+		e := newScrapeError(status, err)
+		return nil, &scrapeError{e.reason, fmt.Errorf("get the usage failed: %v", e.err)}
+	}
+	for i := range usage.Entries {
+		for _, b := range usage.Entries[i].Buckets {
+			l := line(tenantLabels(usage.Entries[i].User, b.Bucket))
+			for _, c := range b.Categories {
+				l.Requests[families.family(c.Category)] += c.Ops
+				l.EgressBytes += c.BytesSent
+			}
+		}
+	}
+
+	var sampledAt *time.Time
+	billed := end
+	if end.After(now) {
+		status, buckets, err := client.GetBucket("", "", true)
+		if err != nil || status > 200 {
+			e := newScrapeError(status, err)
+			return nil, &scrapeError{e.reason, fmt.Errorf("get the bucket stats failed: %v", e.err)}
+		}
+		for i := range buckets {
+			stats := buckets[i].Stats
+			if stats == nil {
+				continue
+			}
+			tenant, user, bucket := tenantLabels(stats.Owner, stats.Bucket)
+			if len(stats.Tenant) != 0 {
+				tenant = stats.Tenant
+			}
+			line(tenant, user, bucket).StorageBytes += stats.Usage[radosgw.BucketUsageMain].Size
+		}
+		sampled := now.UTC()
+		sampledAt, billed = &sampled, now
+	} else {
+		sizes, err := growthStateFor(client.Endpoint()).averageSizes(start, end, *growthSampleInterval)
+		if err != nil {
+			return nil, &scrapeError{reportUncovered, fmt.Errorf("get the stored capacity of the period failed: %v", err)}
+		}
+		for key, size := range sizes {
+			line(tenantLabels(key.Owner, key.Bucket)).StorageBytes += size
+		}
+	}
+
+	result := &chargebackReport{
+		Start:            start,
+		End:              end,
+		Currency:         pricing.Currency,
+		StorageSampledAt: sampledAt,
+		Users:            make([]*reportLine, 0),
+		Buckets:          make([]*reportLine, 0, len(lines)),
+	}
+	users := make(map[lineKey]*reportLine)
+	for key, l := range lines {
+		result.Buckets = append(result.Buckets, l)
+		userKey := lineKey{tenant: key.tenant, user: key.user}
+		u, ok := users[userKey]
+		if !ok {
+			u = &reportLine{Tenant: key.tenant, User: key.user, Requests: make(map[string]int64)}
+			users[userKey] = u
+			result.Users = append(result.Users, u)
+		}
+		u.add(l)
+	}
+	months := billed.Sub(start).Hours() / hoursPerMonth
+	for _, l := range result.Buckets {
+		l.price(pricing, months)
+	}
+	for _, l := range result.Users {
+		l.price(pricing, months)
+	}
+	sortReportLines(result.Users)
+	sortReportLines(result.Buckets)
+	return result, nil
+}
+
+func sortReportLines(lines []*reportLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Tenant != lines[j].Tenant {
+			return lines[i].Tenant < lines[j].Tenant
+		}
+		if lines[i].User != lines[j].User {
+			return lines[i].User < lines[j].User
+		}
+		return lines[i].Bucket < lines[j].Bucket
+	})
+}
+
+var reportColumns = []string{"tenant", "user", "bucket", "storage_bytes", "egress_bytes",
+	"requests", "storage_cost", "request_cost", "egress_cost", "total_cost"}
+
+func (l *reportLine) columns() []string {
+	return []string{l.Tenant, l.User, l.Bucket,
+		strconv.FormatInt(l.StorageBytes, 10),
+		strconv.FormatInt(l.EgressBytes, 10),
+		strconv.FormatInt(l.requests(), 10),
+		formatCost(l.StorageCost), formatCost(l.RequestCost),
+		formatCost(l.EgressCost), formatCost(l.TotalCost)}
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 4, 64)
+}
+
+// write - write the report in the format, the csv one has a scope column telling whether
+// the line is of a user or a bucket
+func (r *chargebackReport) write(w io.Writer, format string) error {
+	switch format {
+	case reportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case reportFormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(append([]string{"scope"}, reportColumns...))
+		for _, l := range r.Users {
+			writer.Write(append([]string{"user"}, l.columns()...))
+		}
+		for _, l := range r.Buckets {
+			writer.Write(append([]string{"bucket"}, l.columns()...))
+		}
+		writer.Flush()
+		return writer.Error()
+	case reportFormatMarkdown:
+		fmt.Fprintf(w, "# Chargeback report %s - %s\n\n", r.Start.Format(reportDateFormat),
+			r.End.Format(reportDateFormat))
+		if r.StorageSampledAt != nil {
+			fmt.Fprintf(w, "Costs are in %s, the storage is sampled at %s.\n", r.Currency,
+				r.StorageSampledAt.Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(w, "Costs are in %s, the storage is averaged in the period.\n", r.Currency)
+		}
+		for _, table := range []struct {
+			title string
+			lines []*reportLine
+		}{{"Users", r.Users}, {"Buckets", r.Buckets}} {
+			fmt.Fprintf(w, "\n## %s\n\n| %s |\n|%s\n", table.title,
+				strings.Join(reportColumns, " | "), strings.Repeat(" --- |", len(reportColumns)))
+			for _, l := range table.lines {
+				fmt.Fprintf(w, "| %s |\n", strings.Join(l.columns(), " | "))
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown report format %s, should be one of csv, json and markdown", format)
+}
+
+// runReport - generate the chargeback report of the radosgw service given by the command
+// line flags or the cluster of the config file, and write it to the standard output
+func runReport(logger *slog.Logger) error {
+	if _, ok := reportContentTypes[*reportFormat]; !ok {
+		return fmt.Errorf("invalid -report.format %s, should be one of csv, json and markdown", *reportFormat)
+	}
+	now := time.Now()
+	start, end, err := parseReportPeriod(*reportPeriod, now)
+	if err != nil {
+		return err
+	}
+	// The samples of the running exporter are only read from its state directory
+	if err := checkReportSamples(start, end, now, len(*growthStateDir) != 0,
+		"set -collector.growth.state.dir to the directory the exporter persists them into, "+
+			"or -report.period to the current month"); err != nil {
+		return err
+	}
+	config := &Config{}
+	if len(*configFile) != 0 {
+		if config, err = LoadConfig(*configFile); err != nil {
+			return err
+		}
+	}
+	credentials, err := flagCredentials()
+	if err != nil {
+		return fmt.Errorf("invalid admin AK/SK for the radosgw service: %v", err)
+	}
+
+	var client *radosgw.Client
+	usageTrim := config.UsageTrim
+	if credentials != nil {
+		if len(config.Clusters) != 0 {
+			return fmt.Errorf("clusters in config file conflict with the credentials flags")
+		}
+		client, err = radosgw.NewClientWithProvider(*endpoint, credentials)
+	} else {
+		var cluster *ClusterConfig
+		for i := range config.Clusters {
+			if config.Clusters[i].Name == *reportCluster ||
+				(len(*reportCluster) == 0 && len(config.Clusters) == 1) {
+				cluster = &config.Clusters[i]
+			}
+		}
+		if cluster == nil {
+			return fmt.Errorf("%v %q, -report.cluster should be one of the config file", errUnknownCluster, *reportCluster)
+		}
+		var provider radosgw.CredentialsProvider
+		if provider, err = cluster.Credentials.provider(); err != nil {
+			return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		client, err = radosgw.NewClientWithProvider(cluster.Endpoint, provider)
+		if cluster.UsageTrim != nil {
+			usageTrim = cluster.UsageTrim
+		}
+	}
+	if err != nil {
+		return err
+	}
+	client.SetLogger(logger)
+	report, err := generateReport(client, &config.Pricing, newOpFamilies(config.OpFamilies),
+		usageTrim, start, end, now)
+	if err != nil {
+		return err
+	}
+	return report.write(os.Stdout, *reportFormat)
+}
+
+// reporter serves the /report endpoint to generate the chargeback report of the cluster
+// given by the cluster parameter, which may be omitted if there is only one, for the
+// period and in the format given by the period and format parameters.
+type reporter struct {
+	manager *clusterManager
+}
+
+func (p *reporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = reportFormatCSV
+	}
+	contentType, ok := reportContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	start, end, err := parseReportPeriod(r.URL.Query().Get("period"), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cluster := r.URL.Query().Get("cluster")
+	client, config, usageTrim, sampled, err := p.manager.reportTarget(cluster)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v %q", err, cluster), http.StatusBadRequest)
+		return
+	}
+	var report *chargebackReport
+	if err = checkReportSamples(start, end, now, sampled, "which should be enabled"); err == nil {
+		report, err = generateReport(client, &config.Pricing, newOpFamilies(config.OpFamilies),
+			usageTrim, start, end, now)
+	}
+	if e, ok := err.(*scrapeError); ok && e.reason == reportUncovered {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	report.write(w, format)
+}
@@ -0,0 +1,255 @@
+// report_test.go - test the chargeback reports
+
+package main
+
+import (
+	"flag"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+var testPricing = &PricingConfig{
+	Currency:       "USD",
+	StorageGBMonth: 0.02,
+	EgressGB:       0.09,
+	Requests:       map[string]float64{opFamilyRead: 0.4},
+}
+
+func testBucketStats(owner, bucket string, size int64) *radosgw.BucketStatsType {
+	return &radosgw.BucketStatsType{
+		Bucket: bucket,
+		Owner:  owner,
+		Usage:  map[string]radosgw.BucketUsageCategoryType{radosgw.BucketUsageMain: {Size: size}},
+	}
+}
+
+func checkPrice(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("got %s %v, want %v", name, got, want)
+	}
+}
+
+func TestReportPricing(t *testing.T) {
+	f := newFakeRadosgw(t)
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	f.setUsage(fakeUsage{"alice", "b1", start.Add(time.Hour), "get_obj",
+		usageValue{BytesSent: 2 * bytesPerGB, Ops: 2000, SuccessfulOps: 2000}})
+	f.setBuckets(testBucketStats("alice", "b1", 10*bytesPerGB), testBucketStats("bob", "b2", bytesPerGB))
+
+	// The current period is billed for the elapsed tenth of a month
+	now := start.Add(hoursPerMonth / 10 * time.Hour)
+	report, err := generateReport(f.client, testPricing, newOpFamilies(nil), nil, start,
+		start.AddDate(0, 1, 0), now)
+	if err != nil {
+		t.Fatalf("generate the report failed: %v", err)
+	}
+	if report.StorageSampledAt == nil || !report.StorageSampledAt.Equal(now) {
+		t.Errorf("got the storage sampled at %v, want %s", report.StorageSampledAt, now)
+	}
+	if len(report.Users) != 2 || len(report.Buckets) != 2 {
+		t.Fatalf("got %d users and %d buckets, want 2 and 2", len(report.Users), len(report.Buckets))
+	}
+	alice := report.Users[0]
+	if alice.User != "alice" || alice.Requests[opFamilyRead] != 2000 || alice.EgressBytes != 2*bytesPerGB {
+		t.Errorf("got the line %+v of alice", alice)
+	}
+	checkPrice(t, "storage cost", alice.StorageCost, 10*0.1*0.02)
+	checkPrice(t, "egress cost", alice.EgressCost, 2*0.09)
+	checkPrice(t, "request cost", alice.RequestCost, 2*0.4)
+	checkPrice(t, "total cost", alice.TotalCost, 10*0.1*0.02+2*0.09+2*0.4)
+	checkPrice(t, "storage cost of bob", report.Users[1].StorageCost, 0.1*0.02)
+}
+
+func TestReportEndedPeriod(t *testing.T) {
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+	sample := func(d time.Duration, size int64) growthSample {
+		return growthSample{start.Add(d).Unix(), size * bytesPerGB}
+	}
+	cases := []struct {
+		name    string
+		samples map[growthKey][]growthSample
+		sizes   map[string]int64
+		err     bool
+	}{
+		{
+			name: "averaged",
+			samples: map[growthKey][]growthSample{
+				{"alice", "b1"}: {sample(0, 10), sample(24*time.Hour, 20), sample(47*time.Hour+30*time.Minute, 20)},
+				// The deleted bucket is billed until one sample interval after its last sample
+				{"alice", "b2"}: {sample(-time.Hour, 48), sample(12*time.Hour, 48)},
+			},
+			sizes: map[string]int64{"b1": 15 * bytesPerGB, "b2": 13 * bytesPerGB},
+		},
+		{
+			name: "created in the period",
+			samples: map[growthKey][]growthSample{
+				{"alice", "b1"}: {sample(0, 0), sample(47*time.Hour, 8)},
+				{"alice", "b2"}: {sample(36*time.Hour, 8), sample(47*time.Hour+30*time.Minute, 8)},
+			},
+			sizes: map[string]int64{"b1": bytesPerGB / 6, "b2": 2 * bytesPerGB},
+		},
+		{
+			name: "sampled since the period",
+			samples: map[growthKey][]growthSample{
+				{"alice", "b1"}: {sample(2*time.Hour, 10), sample(47*time.Hour+30*time.Minute, 10)},
+			},
+			err: true,
+		},
+		{
+			name: "sampled until the period",
+			samples: map[growthKey][]growthSample{
+				{"alice", "b1"}: {sample(0, 10), sample(40*time.Hour, 10)},
+			},
+			err: true,
+		},
+		{name: "not sampled", samples: map[growthKey][]growthSample{}, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newFakeRadosgw(t)
+			state := growthStateFor(f.server.URL)
+			state.loaded, state.samples = true, c.samples
+			report, err := generateReport(f.client, testPricing, newOpFamilies(nil), nil, start, end,
+				end.Add(24*time.Hour))
+			if c.err {
+				if e, ok := err.(*scrapeError); !ok || e.reason != reportUncovered {
+					t.Errorf("got error %v, want the uncovered period", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generate the report failed: %v", err)
+			}
+			if report.StorageSampledAt != nil {
+				t.Errorf("got the storage sampled at %s of the ended period", report.StorageSampledAt)
+			}
+			if len(report.Buckets) != len(c.sizes) {
+				t.Fatalf("got %d buckets, want %d", len(report.Buckets), len(c.sizes))
+			}
+			for _, l := range report.Buckets {
+				if l.StorageBytes != c.sizes[l.Bucket] {
+					t.Errorf("got the average size %d of %s, want %d", l.StorageBytes, l.Bucket, c.sizes[l.Bucket])
+				}
+				checkPrice(t, "storage cost of "+l.Bucket, l.StorageCost,
+					float64(c.sizes[l.Bucket])/bytesPerGB*48/hoursPerMonth*0.02)
+			}
+		})
+	}
+}
+
+func TestReportUsageTrim(t *testing.T) {
+	f := newFakeRadosgw(t)
+	f.setBuckets(testBucketStats("alice", "b1", bytesPerGB))
+	now := time.Date(2026, 9, 20, 0, 0, 0, 0, time.UTC)
+	usageTrim := &UsageTrimConfig{Retention: 30 * 24 * time.Hour}
+	cases := []struct {
+		name  string
+		start time.Time
+		err   bool
+	}{
+		{"within the retention", time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), false},
+		{"before the retention", time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, c := range cases {
+		_, err := generateReport(f.client, testPricing, newOpFamilies(nil), usageTrim, c.start,
+			c.start.AddDate(0, 1, 0), now)
+		if e, ok := err.(*scrapeError); c.err != (ok && e.reason == reportUncovered) {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: generate the report failed: %v", c.name, err)
+		}
+	}
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	now := time.Date(2026, 9, 20, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value      string
+		start, end string
+		err        bool
+	}{
+		{"", "2026-08-01", "2026-09-01", false},
+		{"2026-09", "2026-09-01", "2026-10-01", false},
+		{"2026-09-01/2026-09-15", "2026-09-01", "2026-09-15", false},
+		{"2026-09-15/2026-09-01", "", "", true},
+		{"2026-9", "", "", true},
+		// The periods starting in the future would be billed for negative months
+		{"2026-10", "", "", true},
+		{"2026-09-21/2026-09-30", "", "", true},
+	}
+	for _, c := range cases {
+		start, end, err := parseReportPeriod(c.value, now)
+		if c.err {
+			if err == nil {
+				t.Errorf("got no error of the period %q", c.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("got error %v of the period %q", err, c.value)
+			continue
+		}
+		if got := start.Format(reportDateFormat) + "/" + end.Format(reportDateFormat); got != c.start+"/"+c.end {
+			t.Errorf("got the period %s of %q, want %s/%s", got, c.value, c.start, c.end)
+		}
+	}
+}
+
+// setFlag - set the command line flag during the test
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("set the flag %s failed: %v", name, err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+func TestRunReportDefaultFlags(t *testing.T) {
+	f := newFakeRadosgw(t)
+	setFlag(t, "endpoint", f.server.URL)
+	setFlag(t, "ak", "ak")
+	setFlag(t, "sk", "sk")
+
+	// The last month is not covered by the bucket size samples with the default flags
+	err := runReport(testLogger())
+	if err == nil || !strings.Contains(err.Error(), "-collector.growth.state.dir") {
+		t.Errorf("got error %v, want the configuration error of the growth state dir", err)
+	}
+	setFlag(t, "collector.growth.state.dir", t.TempDir())
+	err = runReport(testLogger())
+	if err == nil || !strings.Contains(err.Error(), "-collector.growth.window") {
+		t.Errorf("got error %v, want the configuration error of the growth window", err)
+	}
+	if n := len(f.recorded("GET /admin/usage")); n != 0 {
+		t.Errorf("got %d usage requests of the uncovered period", n)
+	}
+}
+
+func TestCheckReportSamples(t *testing.T) {
+	now := time.Date(2026, 9, 20, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name       string
+		start, end time.Time
+		sampled    bool
+		err        bool
+	}{
+		{"current period", now.AddDate(0, 0, -19), now.AddDate(0, 0, 11), false, false},
+		{"not sampled", now.AddDate(0, 0, -3), now.AddDate(0, 0, -1), false, true},
+		{"within the window", now.AddDate(0, 0, -3), now.AddDate(0, 0, -1), true, false},
+		{"before the window", now.AddDate(0, 0, -30), now.AddDate(0, 0, -1), true, true},
+	}
+	for _, c := range cases {
+		err := checkReportSamples(c.start, c.end, now, c.sampled, "enable it")
+		if e, ok := err.(*scrapeError); c.err != (ok && e.reason == reportUncovered) {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+	}
+}
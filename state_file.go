@@ -0,0 +1,97 @@
+// state_file.go - implement the shared states of the radosgw endpoints and their state files
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// endpointStates keeps the states of each radosgw endpoint by the kind, so they survive
+	// the configuration reloads which rebuild the collectors and background jobs.
+	endpointStatesMtx sync.Mutex
+	endpointStates    = make(map[endpointStateKey]interface{})
+)
+
+// endpointStateKey identifies a shared state by the kind and the radosgw endpoint.
+type endpointStateKey struct {
+	kind     string
+	endpoint string
+}
+
+// endpointState - get the shared state of the kind of the radosgw endpoint, it is created
+// by the function if not existing yet
+func endpointState(kind, endpoint string, create func() interface{}) interface{} {
+	endpointStatesMtx.Lock()
+	defer endpointStatesMtx.Unlock()
+	key := endpointStateKey{kind, endpoint}
+	if s, ok := endpointStates[key]; ok {
+		return s
+	}
+	s := create()
+	endpointStates[key] = s
+	return s
+}
+
+// stateFile is the JSON file persisting the state of the kind of a radosgw endpoint, named
+// by the kind and the hash of the endpoint. The state is only kept in memory if the
+// directory is empty.
+type stateFile struct {
+	kind     string
+	filename string
+}
+
+func newStateFile(kind, endpoint, dir string) stateFile {
+	f := stateFile{kind: kind}
+	if len(dir) != 0 {
+		sum := sha256.Sum256([]byte(endpoint))
+		f.filename = filepath.Join(dir, kind+"-"+hex.EncodeToString(sum[:8])+".json")
+	}
+	return f
+}
+
+// persisted - check whether the state is persisted into a file
+func (f stateFile) persisted() bool {
+	return len(f.filename) != 0
+}
+
+// read - parse the state file into the value, it returns false if there is no state file
+func (f stateFile) read(v interface{}) (bool, error) {
+	if !f.persisted() {
+		return false, nil
+	}
+	content, err := ioutil.ReadFile(f.filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return false, fmt.Errorf("parse %s state file %s failed: %v", f.kind, f.filename, err)
+	}
+	return true, nil
+}
+
+// write - write the value into the state file atomically by renaming a temporary file
+func (f stateFile) write(v interface{}) error {
+	if !f.persisted() {
+		return nil
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := f.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.filename)
+}
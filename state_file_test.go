@@ -0,0 +1,59 @@
+// state_file_test.go - test the shared states of the radosgw endpoints and their state files
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestStateFile(t *testing.T) {
+	dir := t.TempDir()
+	f := newStateFile("test", "http://rgw-a:8080", dir)
+	for _, other := range []stateFile{newStateFile("test", "http://rgw-b:8080", dir),
+		newStateFile("other", "http://rgw-a:8080", dir)} {
+		if other.filename == f.filename {
+			t.Errorf("got the same state file %s of another endpoint or kind", f.filename)
+		}
+	}
+
+	var value map[string]int
+	if ok, err := f.read(&value); ok || err != nil {
+		t.Fatalf("got the missing state file read %v with error %v", ok, err)
+	}
+	if err := f.write(map[string]int{"a": 1}); err != nil {
+		t.Fatalf("write the state file failed: %v", err)
+	}
+	if ok, err := f.read(&value); !ok || err != nil || value["a"] != 1 {
+		t.Errorf("got the state %v read %v with error %v, want a=1", value, ok, err)
+	}
+	if err := ioutil.WriteFile(f.filename, []byte("{"), 0600); err != nil {
+		t.Fatalf("write the broken state file failed: %v", err)
+	}
+	if _, err := f.read(&value); err == nil {
+		t.Errorf("got no error of the broken state file")
+	}
+
+	// The state is only kept in memory without the directory
+	memory := newStateFile("test", "http://rgw-a:8080", "")
+	if err := memory.write(1); err != nil || memory.persisted() {
+		t.Errorf("got the state without the directory persisted with error %v", err)
+	}
+}
+
+func TestEndpointState(t *testing.T) {
+	created := 0
+	create := func() interface{} {
+		created++
+		return &created
+	}
+	a := endpointState("test", "http://rgw-test-a:8080", create)
+	if endpointState("test", "http://rgw-test-a:8080", create) != a || created != 1 {
+		t.Errorf("got the state of the endpoint created %d times, want shared", created)
+	}
+	endpointState("test", "http://rgw-test-b:8080", create)
+	endpointState("other", "http://rgw-test-a:8080", create)
+	if created != 3 {
+		t.Errorf("got %d states created, want one per kind and endpoint", created)
+	}
+}
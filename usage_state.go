@@ -0,0 +1,477 @@
+// usage_state.go - implement the incremental collection of the usage log
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+// usageSettleTime is how long to wait after an hour ends before its usage log entries
+// are regarded as final, as radosgw flushes the usage log periodically.
+const usageSettleTime = 5 * time.Minute
+
+var (
+	usageStateDir = flag.String("collector.usage.state.dir", "",
+		"directory to persist the usage checkpoints and totals across restarts, empty means only in memory")
+)
+
+// usageKey identifies the usage of an API category on a bucket by a user, the user and
+// bucket are the raw names qualified by the tenant.
+type usageKey struct {
+	User     string `json:"user"`
+	Bucket   string `json:"bucket"`
+	Category string `json:"category"`
+}
+
+// usageValue is the accumulated usage of a key.
+type usageValue struct {
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+	Ops           int64 `json:"ops"`
+	SuccessfulOps int64 `json:"successful_ops"`
+}
+
+func (v *usageValue) add(o usageValue) {
+	v.BytesSent += o.BytesSent
+	v.BytesReceived += o.BytesReceived
+	v.Ops += o.Ops
+	v.SuccessfulOps += o.SuccessfulOps
+}
+
+// shrunk - check whether any value is less than the last seen one
+func (v usageValue) shrunk(last usageValue) bool {
+	return v.BytesSent < last.BytesSent || v.BytesReceived < last.BytesReceived ||
+		v.Ops < last.Ops || v.SuccessfulOps < last.SuccessfulOps
+}
+
+// usageRecord is a usage value of the state file.
+type usageRecord struct {
+	usageKey
+	Epoch int64 `json:"epoch,omitempty"`
+	usageValue
+}
+
+// usageSnapshot is the result of an update of the usage state.
+type usageSnapshot struct {
+	totals     map[usageKey]usageValue
+	userTotals map[usageKey]usageValue
+	checkpoint time.Time
+
+	// reconciled counts the entries found trimmed from the usage log so far.
+	reconciled int
+}
+
+// usageStateFile is the content of the persisted state file.
+type usageStateFile struct {
+	Endpoint   string        `json:"endpoint"`
+	Output     string        `json:"output,omitempty"`
+	Checkpoint int64         `json:"checkpoint"`
+	Trimmed    int64         `json:"trimmed,omitempty"`
+	Totals     []usageRecord `json:"totals"`
+	Open       []usageRecord `json:"open"`
+	UserTotals []usageRecord `json:"user_totals,omitempty"`
+	UserOpen   []usageRecord `json:"user_open,omitempty"`
+}
+
+// openUsageKey identifies a usage log entry of an hour which may still change.
+type openUsageKey struct {
+	usageKey
+	epoch int64
+}
+
+// usageState accumulates the usage log incrementally. The entries of the hours before
+// the checkpoint are final and summed up to the totals, so only the entries after the
+// checkpoint are fetched. The entries after it are kept separately with the last seen
+// values, once an entry shrinks or disappears it is regarded as trimmed and the last
+// seen values are moved to the totals, so the exported totals never go backwards.
+type usageState struct {
+	endpoint string
+	file     stateFile
+
+	mtx        sync.Mutex
+	loaded     bool
+	output     string
+	checkpoint time.Time
+	saved      time.Time
+	totals     map[usageKey]usageValue
+	open       map[openUsageKey]usageValue
+	reconciled int
+
+	// trimmed is the end of the usage log trimmed by the exporter, the entries before it
+	// can not be collected again.
+	trimmed time.Time
+
+	// userTotals and userOpen are the totals and open usage of each user and category,
+	// with the empty bucket, only kept if the users are the only output.
+	userTotals map[usageKey]usageValue
+	userOpen   map[usageKey]usageValue
+}
+
+// usageStateFor - get the shared state of the radosgw endpoint
+func usageStateFor(endpoint string) *usageState {
+	return endpointState("usage", endpoint, func() interface{} {
+		return newUsageState(endpoint, *usageStateDir)
+	}).(*usageState)
+}
+
+// newUsageState - create the state of the radosgw endpoint persisted into the directory
+func newUsageState(endpoint, dir string) *usageState {
+	return &usageState{
+		endpoint:   endpoint,
+		file:       newStateFile("usage", endpoint, dir),
+		totals:     make(map[usageKey]usageValue),
+		open:       make(map[openUsageKey]usageValue),
+		userTotals: make(map[usageKey]usageValue),
+		userOpen:   make(map[usageKey]usageValue),
+	}
+}
+
+// update - fetch the usage log after the checkpoint and accumulate it by the output, the
+// per-user totals are summed up from the bucket totals unless only they are collected
+func (s *usageState) update(client *radosgw.Client, now time.Time, output string) (*usageSnapshot, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return nil, &scrapeError{"state_file", err}
+		}
+		s.loaded = true
+	}
+	if err := s.switchOutput(output); err != nil {
+		return nil, &scrapeError{"output_switch", err}
+	}
+
+	boundary := now.Add(-usageSettleTime).Truncate(time.Hour).UTC()
+	if boundary.Before(s.checkpoint) {
+		boundary = s.checkpoint
+	}
+	var err error
+	if output == usageOutputUsers {
+		err = s.updateSummary(client, boundary)
+	} else {
+		err = s.updateEntries(client, boundary)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.checkpoint = boundary
+	if err := s.save(); err != nil {
+		return nil, &scrapeError{"state_file", err}
+	}
+	s.saved = s.checkpoint
+
+	result := &usageSnapshot{
+		checkpoint: s.checkpoint,
+		reconciled: s.reconciled,
+	}
+	if output == usageOutputUsers {
+		result.userTotals = mergeUsageTotals(s.userTotals, s.userOpen)
+		return result, nil
+	}
+	result.totals = make(map[usageKey]usageValue, len(s.totals))
+	for key, value := range s.totals {
+		result.totals[key] = value
+	}
+	for okey, value := range s.open {
+		total := result.totals[okey.usageKey]
+		total.add(value)
+		result.totals[okey.usageKey] = total
+	}
+	if output == usageOutputBoth {
+		result.userTotals = make(map[usageKey]usageValue)
+		for key, value := range result.totals {
+			userKey := usageKey{User: key.User, Category: key.Category}
+			total := result.userTotals[userKey]
+			total.add(value)
+			result.userTotals[userKey] = total
+		}
+	}
+	return result, nil
+}
+
+// switchOutput - convert the accumulated usage once the output changes. The user totals
+// are summed up from the bucket totals, while the bucket totals cannot be restored from
+// the user totals, so they are collected again from the entries left in the usage log.
+// It is refused once the usage log is trimmed, as the totals would go backwards.
+func (s *usageState) switchOutput(output string) error {
+	if s.output == usageOutputUsers && output != usageOutputUsers && !s.trimmed.IsZero() {
+		return fmt.Errorf("can not switch the usage output from users to %s as the usage log before %s "+
+			"is trimmed, keep the users output or remove the state file %s to start over",
+			output, s.trimmed.Format(time.RFC3339), s.file.filename)
+	}
+	switch {
+	case s.output == output || len(s.output) == 0:
+	case output == usageOutputUsers:
+		s.userTotals = make(map[usageKey]usageValue)
+		s.userOpen = make(map[usageKey]usageValue)
+		for key, value := range s.totals {
+			addUsage(s.userTotals, usageKey{User: key.User, Category: key.Category}, value)
+		}
+		for okey, value := range s.open {
+			addUsage(s.userOpen, usageKey{User: okey.User, Category: okey.Category}, value)
+		}
+		s.totals = make(map[usageKey]usageValue)
+		s.open = make(map[openUsageKey]usageValue)
+	case s.output == usageOutputUsers:
+		s.checkpoint = time.Time{}
+		s.userTotals = make(map[usageKey]usageValue)
+		s.userOpen = make(map[usageKey]usageValue)
+	}
+	s.output = output
+	return nil
+}
+
+// updateEntries - fetch the usage log entries after the checkpoint, the ones before the
+// boundary are accumulated to the totals and the other ones are kept as open
+func (s *usageState) updateEntries(client *radosgw.Client, boundary time.Time) error {
+	var start *time.Time
+	if !s.checkpoint.IsZero() {
+		start = &s.checkpoint
+	}
+	status, usage, err := client.GetUsage("", start, nil, false, true)
+	if err != nil || status > 200 {
+		return newScrapeError(status, err)
+	}
+	open := make(map[openUsageKey]usageValue)
+	for i := range usage.Entries {
+		user := usage.Entries[i].User
+		for _, b := range usage.Entries[i].Buckets {
+			epoch := time.Unix(b.Epoch, 0)
+			if epoch.Before(s.checkpoint) {
+				continue
+			}
+			for _, c := range b.Categories {
+				key := usageKey{user, b.Bucket, c.Category}
+				value := usageValue{c.BytesSent, c.BytesReceived, c.Ops, c.SuccessfulOps}
+				if epoch.Before(boundary) {
+					addUsage(s.totals, key, value)
+					delete(s.open, openUsageKey{key, b.Epoch})
+					continue
+				}
+				okey := openUsageKey{key, b.Epoch}
+				current := open[okey]
+				current.add(value)
+				open[okey] = current
+			}
+		}
+	}
+
+	// Move the last seen values of the trimmed entries to the totals, including the ones
+	// of the closed hours which are not fetched as final
+	for okey, last := range s.open {
+		if current, ok := open[okey]; !ok || current.shrunk(last) {
+			addUsage(s.totals, okey.usageKey, last)
+			s.reconciled++
+		}
+	}
+	s.open = open
+	return nil
+}
+
+// updateSummary - fetch the usage summary of each user instead of the entries, which is
+// much smaller on large clusters. The summary of the hours between the checkpoint and the
+// boundary is accumulated to the user totals, and the one after the boundary is kept as
+// open. The user totals never go backwards in the same way as the bucket totals.
+func (s *usageState) updateSummary(client *radosgw.Client, boundary time.Time) error {
+	if boundary.After(s.checkpoint) {
+		var start *time.Time
+		if !s.checkpoint.IsZero() {
+			start = &s.checkpoint
+		}
+		final, err := getUsageSummary(client, start, &boundary)
+		if err != nil {
+			return err
+		}
+		for key, value := range final {
+			if last, ok := s.userOpen[key]; ok && value.shrunk(last) {
+				value = last
+				s.reconciled++
+			}
+			addUsage(s.userTotals, key, value)
+		}
+		for key, last := range s.userOpen {
+			if _, ok := final[key]; !ok {
+				addUsage(s.userTotals, key, last)
+				s.reconciled++
+			}
+		}
+		s.userOpen = make(map[usageKey]usageValue)
+	}
+
+	open, err := getUsageSummary(client, &boundary, nil)
+	if err != nil {
+		return err
+	}
+	for key, last := range s.userOpen {
+		if current, ok := open[key]; !ok || current.shrunk(last) {
+			addUsage(s.userTotals, key, last)
+			s.reconciled++
+		}
+	}
+	s.userOpen = open
+	return nil
+}
+
+// getUsageSummary - get the usage of each user and category in the time range
+func getUsageSummary(client *radosgw.Client, start, end *time.Time) (map[usageKey]usageValue, error) {
+	status, usage, err := client.GetUsage("", start, end, true, false)
+	if err != nil || status > 200 {
+		return nil, newScrapeError(status, err)
+	}
+	result := make(map[usageKey]usageValue)
+	for i := range usage.Summary {
+		for _, c := range usage.Summary[i].Categories {
+			addUsage(result, usageKey{User: usage.Summary[i].User, Category: c.Category},
+				usageValue{c.BytesSent, c.BytesReceived, c.Ops, c.SuccessfulOps})
+		}
+	}
+	return result, nil
+}
+
+// markTrimmed - record the usage log before the end is trimmed, it is saved at once as the
+// trimmed entries are gone whether the next update succeeds or not
+func (s *usageState) markTrimmed(end time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return err
+		}
+		s.loaded = true
+	}
+	if !end.After(s.trimmed) {
+		return nil
+	}
+	s.trimmed = end
+	return s.save()
+}
+
+// savedCheckpoint - get the checkpoint persisted to the state file, the usage log entries
+// before it are safe to be trimmed. It is zero if the state is only kept in memory or
+// has not been saved yet.
+func (s *usageState) savedCheckpoint() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.file.persisted() {
+		return time.Time{}
+	}
+	return s.saved
+}
+
+func addUsage(totals map[usageKey]usageValue, key usageKey, value usageValue) {
+	total := totals[key]
+	total.add(value)
+	totals[key] = total
+}
+
+func mergeUsageTotals(totals, open map[usageKey]usageValue) map[usageKey]usageValue {
+	result := make(map[usageKey]usageValue, len(totals))
+	for key, value := range totals {
+		result[key] = value
+	}
+	for key, value := range open {
+		addUsage(result, key, value)
+	}
+	return result
+}
+
+// load - restore the state from the state file if it exists
+func (s *usageState) load() error {
+	file := &usageStateFile{}
+	if ok, err := s.file.read(file); !ok {
+		return err
+	}
+	s.checkpoint = time.Unix(file.Checkpoint, 0).UTC()
+	if file.Trimmed != 0 {
+		s.trimmed = time.Unix(file.Trimmed, 0).UTC()
+	}
+	s.output = file.Output
+	if len(s.output) == 0 && file.Checkpoint != 0 {
+		// The state files written before the output was saved
+		s.output = usageOutputBoth
+		if len(file.UserTotals) != 0 || len(file.UserOpen) != 0 {
+			s.output = usageOutputUsers
+		}
+	}
+	for _, r := range file.Totals {
+		s.totals[r.usageKey] = r.usageValue
+	}
+	for _, r := range file.Open {
+		s.open[openUsageKey{r.usageKey, r.Epoch}] = r.usageValue
+	}
+	for _, r := range file.UserTotals {
+		s.userTotals[r.usageKey] = r.usageValue
+	}
+	for _, r := range file.UserOpen {
+		s.userOpen[r.usageKey] = r.usageValue
+	}
+	return nil
+}
+
+// save - write the state into the state file
+func (s *usageState) save() error {
+	if !s.file.persisted() {
+		return nil
+	}
+	file := &usageStateFile{
+		Endpoint:   s.endpoint,
+		Output:     s.output,
+		Checkpoint: s.checkpoint.Unix(),
+		Totals:     make([]usageRecord, 0, len(s.totals)),
+		Open:       make([]usageRecord, 0, len(s.open)),
+	}
+	if !s.trimmed.IsZero() {
+		file.Trimmed = s.trimmed.Unix()
+	}
+	for key, value := range s.totals {
+		file.Totals = append(file.Totals, usageRecord{usageKey: key, usageValue: value})
+	}
+	for okey, value := range s.open {
+		file.Open = append(file.Open, usageRecord{okey.usageKey, okey.epoch, value})
+	}
+	for key, value := range s.userTotals {
+		file.UserTotals = append(file.UserTotals, usageRecord{usageKey: key, usageValue: value})
+	}
+	for key, value := range s.userOpen {
+		file.UserOpen = append(file.UserOpen, usageRecord{usageKey: key, usageValue: value})
+	}
+	sortUsageRecords(file.Totals)
+	sortUsageRecords(file.Open)
+	sortUsageRecords(file.UserTotals)
+	sortUsageRecords(file.UserOpen)
+	return s.file.write(file)
+}
+
+func (k usageKey) less(o usageKey) bool {
+	if k.User != o.User {
+		return k.User < o.User
+	}
+	if k.Bucket != o.Bucket {
+		return k.Bucket < o.Bucket
+	}
+	return k.Category < o.Category
+}
+
+func sortedUsageKeys(totals map[usageKey]usageValue) []usageKey {
+	keys := make([]usageKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	return keys
+}
+
+func sortUsageRecords(records []usageRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].usageKey != records[j].usageKey {
+			return records[i].less(records[j].usageKey)
+		}
+		return records[i].Epoch < records[j].Epoch
+	})
+}
@@ -0,0 +1,207 @@
+// usage_state_test.go - test the incremental collection of the usage log
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	testBucketKey = usageKey{User: "alice", Bucket: "b1", Category: "get_obj"}
+	testUserKey   = usageKey{User: "alice", Category: "get_obj"}
+)
+
+// testHour - get the time of the hour and minute of the test day
+func testHour(hour, minute int) time.Time {
+	return time.Date(2026, 1, 1, hour, minute, 0, 0, time.UTC)
+}
+
+// testUsage - get the usage log entry of the test bucket in the hour
+func testUsage(hour int, ops, successful int64) fakeUsage {
+	return fakeUsage{"alice", "b1", testHour(hour, 0), "get_obj", usageValue{Ops: ops, SuccessfulOps: successful}}
+}
+
+func updateUsageState(t *testing.T, s *usageState, f *fakeRadosgw, now time.Time,
+	output string) *usageSnapshot {
+	t.Helper()
+	snapshot, err := s.update(f.client, now, output)
+	if err != nil {
+		t.Fatalf("update the usage state at %s failed: %v", now.Format(time.Kitchen), err)
+	}
+	return snapshot
+}
+
+func checkOps(t *testing.T, name string, totals map[usageKey]usageValue, key usageKey, ops, successful int64) {
+	t.Helper()
+	if v := totals[key]; v.Ops != ops || v.SuccessfulOps != successful {
+		t.Errorf("%s: got %d ops and %d successful ops, want %d and %d", name, v.Ops,
+			v.SuccessfulOps, ops, successful)
+	}
+}
+
+func TestUsageStateCheckpoint(t *testing.T) {
+	f := newFakeRadosgw(t)
+	s := newUsageState(f.server.URL, "")
+	f.setUsage(testUsage(9, 10, 9), testUsage(10, 5, 5))
+	snapshot := updateUsageState(t, s, f, testHour(10, 30), usageOutputBoth)
+	if !snapshot.checkpoint.Equal(testHour(10, 0)) {
+		t.Errorf("got checkpoint %s, want 10:00", snapshot.checkpoint)
+	}
+	checkOps(t, "first update", snapshot.totals, testBucketKey, 15, 14)
+
+	// The hour of the open entry ends, its final value replaces the last seen one
+	f.setUsage(testUsage(9, 10, 9), testUsage(10, 8, 7), testUsage(11, 2, 2))
+	snapshot = updateUsageState(t, s, f, testHour(11, 10), usageOutputBoth)
+	if !snapshot.checkpoint.Equal(testHour(11, 0)) {
+		t.Errorf("got checkpoint %s, want 11:00", snapshot.checkpoint)
+	}
+	checkOps(t, "across the hour", snapshot.totals, testBucketKey, 20, 18)
+	requests := f.recorded("GET /admin/usage")
+	if start := requests[len(requests)-1].URL.Query().Get("start"); start != "2026-01-01 10:00:00" {
+		t.Errorf("got the usage fetched since %q, want the checkpoint 10:00", start)
+	}
+	checkOps(t, "per user", snapshot.userTotals, testUserKey, 20, 18)
+
+	// The final hours trimmed from the usage log are kept in the totals
+	f.setUsage(testUsage(11, 2, 2))
+	snapshot = updateUsageState(t, s, f, testHour(11, 20), usageOutputBoth)
+	checkOps(t, "after trimmed", snapshot.totals, testBucketKey, 20, 18)
+	if snapshot.reconciled != 0 {
+		t.Errorf("got %d reconciled entries, want 0", snapshot.reconciled)
+	}
+}
+
+func TestUsageStateOpenEntries(t *testing.T) {
+	cases := []struct {
+		name       string
+		usage      []fakeUsage
+		ops        int64
+		reconciled int
+	}{
+		{"first read", []fakeUsage{testUsage(10, 5, 5)}, 5, 0},
+		{"grown", []fakeUsage{testUsage(10, 7, 6)}, 7, 0},
+		{"unchanged", []fakeUsage{testUsage(10, 7, 6)}, 7, 0},
+		{"trimmed", nil, 7, 1},
+		{"written again", []fakeUsage{testUsage(10, 1, 1)}, 8, 1},
+		{"shrunk", []fakeUsage{testUsage(10, 0, 0)}, 8, 2},
+	}
+	f := newFakeRadosgw(t)
+	s := newUsageState(f.server.URL, "")
+	for i, c := range cases {
+		f.setUsage(c.usage...)
+		snapshot := updateUsageState(t, s, f, testHour(10, 10+i), usageOutputBuckets)
+		if v := snapshot.totals[testBucketKey]; v.Ops != c.ops {
+			t.Errorf("%s: got %d ops, want %d", c.name, v.Ops, c.ops)
+		}
+		if snapshot.reconciled != c.reconciled {
+			t.Errorf("%s: got %d reconciled entries, want %d", c.name, snapshot.reconciled, c.reconciled)
+		}
+	}
+}
+
+func TestUsageStateReload(t *testing.T) {
+	dir := t.TempDir()
+	f := newFakeRadosgw(t)
+	f.setUsage(testUsage(9, 10, 9), testUsage(10, 5, 5))
+	s := newUsageState(f.server.URL, dir)
+	updateUsageState(t, s, f, testHour(10, 30), usageOutputBoth)
+	if _, err := os.Stat(s.file.filename); err != nil {
+		t.Fatalf("the state file is not saved: %v", err)
+	}
+	if !s.savedCheckpoint().Equal(testHour(10, 0)) {
+		t.Errorf("got saved checkpoint %s, want 10:00", s.savedCheckpoint())
+	}
+
+	// The restarted exporter continues from the checkpoint with the final hours trimmed
+	f.setUsage(testUsage(10, 6, 5))
+	reloaded := newUsageState(f.server.URL, dir)
+	snapshot := updateUsageState(t, reloaded, f, testHour(10, 40), usageOutputBoth)
+	checkOps(t, "reloaded", snapshot.totals, testBucketKey, 16, 14)
+	if !snapshot.checkpoint.Equal(testHour(10, 0)) {
+		t.Errorf("got checkpoint %s, want 10:00", snapshot.checkpoint)
+	}
+
+	other := newUsageState(f.server.URL+"/other", dir)
+	if other.file.filename == s.file.filename {
+		t.Errorf("got the same state file %s of the different endpoints", s.file.filename)
+	}
+}
+
+func TestUsageStateSwitchOutput(t *testing.T) {
+	cases := []struct {
+		name   string
+		from   string
+		to     string
+		bucket int64
+		user   int64
+	}{
+		// The user totals are summed up from the bucket totals including the trimmed hours
+		{"buckets to users", usageOutputBuckets, usageOutputUsers, 0, 16},
+		{"both to users", usageOutputBoth, usageOutputUsers, 0, 16},
+		// The bucket totals are collected again from the entries left in the usage log
+		{"users to buckets", usageOutputUsers, usageOutputBuckets, 6, 0},
+		{"users to both", usageOutputUsers, usageOutputBoth, 6, 6},
+		{"buckets to both", usageOutputBuckets, usageOutputBoth, 16, 16},
+		{"both to buckets", usageOutputBoth, usageOutputBuckets, 16, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			f := newFakeRadosgw(t)
+			f.setUsage(testUsage(9, 10, 9), testUsage(10, 5, 5))
+			updateUsageState(t, newUsageState(f.server.URL, dir), f, testHour(10, 30), c.from)
+
+			// The output is switched by restarting with the state file
+			f.setUsage(testUsage(10, 6, 6))
+			snapshot := updateUsageState(t, newUsageState(f.server.URL, dir), f, testHour(10, 40), c.to)
+			if v := snapshot.totals[testBucketKey]; v.Ops != c.bucket {
+				t.Errorf("got %d ops of the bucket, want %d", v.Ops, c.bucket)
+			}
+			if v := snapshot.userTotals[testUserKey]; v.Ops != c.user {
+				t.Errorf("got %d ops of the user, want %d", v.Ops, c.user)
+			}
+		})
+	}
+}
+
+func TestUsageStateSwitchTrimmed(t *testing.T) {
+	cases := []struct {
+		name   string
+		dryRun bool
+		err    bool
+	}{
+		{"trimmed", false, true},
+		{"dry run", true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			f := newFakeRadosgw(t)
+			f.setUsage(testUsage(8, 3, 3), testUsage(9, 10, 9), testUsage(10, 5, 5))
+			s := newUsageState(f.server.URL, dir)
+			endpointStatesMtx.Lock()
+			endpointStates[endpointStateKey{"usage", f.server.URL}] = s
+			endpointStatesMtx.Unlock()
+			updateUsageState(t, s, f, testHour(10, 30), usageOutputUsers)
+			trimmer := newUsageTrimmer(f.client, &UsageTrimConfig{Retention: time.Hour, DryRun: c.dryRun},
+				testLogger())
+			trimmer.trim(testHour(10, 40))
+
+			// The bucket totals can not be collected again from the trimmed usage log
+			_, err := newUsageState(f.server.URL, dir).update(f.client, testHour(10, 50), usageOutputBuckets)
+			if !c.err {
+				if err != nil {
+					t.Errorf("got error %v of switching the output", err)
+				}
+				return
+			}
+			if e, ok := err.(*scrapeError); !ok || e.reason != "output_switch" {
+				t.Errorf("got error %v, want the refused output switch", err)
+			}
+			snapshot := updateUsageState(t, newUsageState(f.server.URL, dir), f, testHour(10, 50), usageOutputUsers)
+			checkOps(t, "users output kept", snapshot.userTotals, testUserKey, 18, 17)
+		})
+	}
+}
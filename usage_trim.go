@@ -0,0 +1,152 @@
+// usage_trim.go - implement the trimming of the usage log after checkpointing
+
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oshynsong/radosgw_exporter/radosgw"
+)
+
+const defaultUsageTrimInterval = time.Hour
+
+// usageTrimmer deletes the usage log entries older than the retention periodically. Only
+// the entries before the checkpoint persisted by the usage collector are deleted, so the
+// exported totals never lose them.
+type usageTrimmer struct {
+	client *radosgw.Client
+	config *UsageTrimConfig
+	logger *slog.Logger
+
+	// trimmedEntries counts the usage log entries trimmed or to be trimmed in dry run.
+	trimmedEntries *prometheus.CounterVec
+
+	// trimErrors counts the failed trimming requests.
+	trimErrors prometheus.Counter
+
+	// lastTrimSuccessTime shows when all users were trimmed for the last time.
+	lastTrimSuccessTime prometheus.Gauge
+}
+
+func newUsageTrimmer(client *radosgw.Client, config *UsageTrimConfig, logger *slog.Logger) *usageTrimmer {
+	return &usageTrimmer{
+		client: client,
+		config: config,
+		logger: logger.With("endpoint", client.Endpoint()),
+		trimmedEntries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "usage_trimmed_entries_total",
+			Help:      "total number of usage log entries trimmed, or only counted in dry run",
+		}, []string{"dry_run"}),
+		trimErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "usage_trim_errors_total",
+			Help:      "total number of failed usage log trimming requests",
+		}),
+		lastTrimSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: radosgwNamespace,
+			Subsystem: "exporter",
+			Name:      "usage_last_trim_success_timestamp_seconds",
+			Help:      "unix timestamp of the last usage log trimming in which all users succeeded",
+		}),
+	}
+}
+
+func (t *usageTrimmer) Describe(ch chan<- *prometheus.Desc) {
+	t.trimmedEntries.Describe(ch)
+	t.trimErrors.Describe(ch)
+	t.lastTrimSuccessTime.Describe(ch)
+}
+
+func (t *usageTrimmer) Collect(ch chan<- prometheus.Metric) {
+	t.trimmedEntries.Collect(ch)
+	t.trimErrors.Collect(ch)
+	t.lastTrimSuccessTime.Collect(ch)
+}
+
+// Run - trim the usage log every interval until the stop channel is closed, the first
+// trimming waits an interval to let the usage collector persist its checkpoint.
+func (t *usageTrimmer) Run(stop <-chan struct{}) {
+	interval := t.config.Interval
+	if interval == 0 {
+		interval = defaultUsageTrimInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.trim(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// trim - delete the usage log entries before the retention and the persisted checkpoint
+func (t *usageTrimmer) trim(now time.Time) {
+	checkpoint := usageStateFor(t.client.Endpoint()).savedCheckpoint()
+	if checkpoint.IsZero() {
+		t.logger.Warn("skip trimming the usage log as no usage checkpoint is persisted yet")
+		return
+	}
+	end := now.Add(-t.config.Retention).Truncate(time.Hour).UTC()
+	if checkpoint.Before(end) {
+		end = checkpoint
+	}
+
+	users := t.config.Users
+	if len(users) == 0 {
+		users = []string{""}
+	}
+	failed, trimmed := false, false
+	for _, uid := range users {
+		entries, err := t.trimUser(uid, end)
+		if err != nil {
+			failed = true
+			t.trimErrors.Inc()
+			t.logger.Error("trim the usage log failed", "user", uid, "end", end, "err", err)
+			continue
+		}
+		trimmed = trimmed || (entries != 0 && !t.config.DryRun)
+		t.trimmedEntries.WithLabelValues(strconv.FormatBool(t.config.DryRun)).Add(float64(entries))
+		t.logger.Info("trim the usage log succeeded", "user", uid, "end", end,
+			"entries", entries, "dry_run", t.config.DryRun)
+	}
+	if trimmed {
+		// The usage output can not be switched from users to buckets once trimmed
+		if err := usageStateFor(t.client.Endpoint()).markTrimmed(end); err != nil {
+			t.logger.Error("save the trimmed usage log to the state failed", "end", end, "err", err)
+		}
+	}
+	if !failed {
+		t.lastTrimSuccessTime.Set(float64(now.Unix()))
+	}
+}
+
+// trimUser - count the usage log entries of the user before the end and delete them
+// unless in dry run, all users are trimmed if the uid is empty
+func (t *usageTrimmer) trimUser(uid string, end time.Time) (int, error) {
+	status, usage, err := t.client.GetUsage(uid, nil, &end, false, true)
+	if err != nil || status > 200 {
+		return 0, newScrapeError(status, err).err
+	}
+	entries := 0
+	for i := range usage.Entries {
+		entries += len(usage.Entries[i].Buckets)
+	}
+	if entries == 0 || t.config.DryRun {
+		return entries, nil
+	}
+	status, err = t.client.DeleteUsage(uid, nil, &end, len(uid) == 0)
+	if err != nil || status > 200 {
+		return 0, newScrapeError(status, err).err
+	}
+	return entries, nil
+}
@@ -0,0 +1,213 @@
+// web.go - implement the TLS and basic auth protection of the exporter web endpoint
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// dummyBcryptHash is the hash of an empty password with the default cost
+const dummyBcryptHash = "$2a$10$FVQ33lE1t2UcZntAKmwIf.KrWz4eqPkuHUvHxHOuVo05xFfUQWhCm"
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// WebConfig stands for the content of the web configuration file
+type WebConfig struct {
+	TLSConfig *TLSConfig `yaml:"tls_server_config"`
+
+	// BasicAuthUsers maps the user names to the bcrypt hashed passwords.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig describes the certificates used to serve HTTPS, the files are loaded again
+// once they are changed
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	MinVersion   string `yaml:"min_version"`
+}
+
+// LoadWebConfig - load and validate the web configuration file
+func LoadWebConfig(filename string) (*WebConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &WebConfig{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parse web config file %s failed: %v", filename, err)
+	}
+	for user, hashed := range cfg.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hashed)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt password of user %s: %v", user, err)
+		}
+	}
+	if cfg.TLSConfig != nil {
+		if len(cfg.TLSConfig.CertFile) == 0 || len(cfg.TLSConfig.KeyFile) == 0 {
+			return nil, fmt.Errorf("cert_file and key_file should not be empty")
+		}
+		if _, ok := tlsVersions[cfg.TLSConfig.MinVersion]; !ok && len(cfg.TLSConfig.MinVersion) != 0 {
+			return nil, fmt.Errorf("unknown min_version %s", cfg.TLSConfig.MinVersion)
+		}
+	}
+	return cfg, nil
+}
+
+// certReloader builds the server side TLS config and rebuilds it once the certificate,
+// key or client CA file is changed.
+type certReloader struct {
+	config *TLSConfig
+	logger *slog.Logger
+
+	mtx      sync.Mutex
+	modTimes [3]time.Time
+	current  *tls.Config
+}
+
+func newCertReloader(config *TLSConfig, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{config: config, logger: logger}
+	if _, err := r.get(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// tlsConfig - get the TLS config for the listener, the real one of each connection is
+// returned by the GetConfigForClient callback
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) { return r.get() },
+	}
+}
+
+func (r *certReloader) get() (*tls.Config, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var modTimes [3]time.Time
+	for i, name := range []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile} {
+		if len(name) == 0 {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			if r.current != nil {
+				return r.current, nil
+			}
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if r.current != nil && modTimes == r.modTimes {
+		return r.current, nil
+	}
+
+	result, err := r.load()
+	if err != nil {
+		if r.current != nil {
+			r.logger.Error("reload the certificates failed, keep using the current ones", "err", err)
+			return r.current, nil
+		}
+		return nil, err
+	}
+	if r.current != nil {
+		r.logger.Info("reload the certificates succeeded", "cert_file", r.config.CertFile)
+	}
+	r.current, r.modTimes = result, modTimes
+	return result, nil
+}
+
+func (r *certReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	result := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(r.config.MinVersion) != 0 {
+		result.MinVersion = tlsVersions[r.config.MinVersion]
+	}
+	if len(r.config.ClientCAFile) != 0 {
+		pem, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", r.config.ClientCAFile)
+		}
+		result.ClientCAs = pool
+		result.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return result, nil
+}
+
+// basicAuthHandler protects the handler by the basic auth users, the successfully
+// verified passwords are cached to avoid the expensive bcrypt comparison on each scrape.
+type basicAuthHandler struct {
+	users   map[string]string
+	handler http.Handler
+
+	mtx      sync.Mutex
+	verified map[[sha256.Size]byte]bool
+}
+
+func newBasicAuthHandler(users map[string]string, handler http.Handler) *basicAuthHandler {
+	return &basicAuthHandler{
+		users:    users,
+		handler:  handler,
+		verified: make(map[[sha256.Size]byte]bool),
+	}
+}
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || !h.verify(user, pass) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="radosgw_exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *basicAuthHandler) verify(user, pass string) bool {
+	hashed, ok := h.users[user]
+	if !ok {
+		// Compare with a dummy hash anyway to not reveal the existing users by timing
+		bcrypt.CompareHashAndPassword([]byte(dummyBcryptHash), []byte(pass))
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hashed))
+	h.mtx.Lock()
+	verified := h.verified[key]
+	h.mtx.Unlock()
+	if verified {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pass)) != nil {
+		return false
+	}
+	h.mtx.Lock()
+	h.verified[key] = true
+	h.mtx.Unlock()
+	return true
+}
@@ -0,0 +1,203 @@
+// web_test.go - test the TLS and basic auth protection of the exporter web endpoint
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testBcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash the password failed: %v", err)
+	}
+	return string(hashed)
+}
+
+func TestBasicAuthHandler(t *testing.T) {
+	h := newBasicAuthHandler(map[string]string{"alice": testBcryptHash(t, "secret")},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cases := []struct {
+		name       string
+		user, pass string
+		noAuth     bool
+		status     int
+	}{
+		{"verified", "alice", "secret", false, http.StatusOK},
+		{"verified again", "alice", "secret", false, http.StatusOK},
+		{"wrong password", "alice", "wrong", false, http.StatusUnauthorized},
+		{"unknown user", "bob", "secret", false, http.StatusUnauthorized},
+		{"no auth", "", "", true, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if !c.noAuth {
+			r.SetBasicAuth(c.user, c.pass)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: got status %d, want %d", c.name, w.Code, c.status)
+		}
+		if c.status == http.StatusUnauthorized && len(w.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("%s: got no WWW-Authenticate header", c.name)
+		}
+	}
+
+	// Only the verified password is cached
+	if len(h.verified) != 1 {
+		t.Errorf("got %d cached passwords, want 1", len(h.verified))
+	}
+}
+
+func TestBasicAuthCacheChangedHash(t *testing.T) {
+	h := newBasicAuthHandler(map[string]string{"alice": testBcryptHash(t, "secret")}, http.NotFoundHandler())
+	if !h.verify("alice", "secret") {
+		t.Fatalf("got the password not verified")
+	}
+
+	// The cached password is not accepted once the hash is changed
+	h.users["alice"] = testBcryptHash(t, "changed")
+	if h.verify("alice", "secret") {
+		t.Errorf("got the old password verified by the cache")
+	}
+	if !h.verify("alice", "changed") {
+		t.Errorf("got the new password not verified")
+	}
+}
+
+// writeTestCert - write a self-signed certificate and its key of the common name
+func writeTestCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate the key failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create the certificate failed: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal the key failed: %v", err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatalf("write the certificate failed: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatalf("write the key failed: %v", err)
+	}
+}
+
+// touchFiles - move the modification time of the files forward to be seen changed
+func touchFiles(t *testing.T, mtime time.Time, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatalf("touch %s failed: %v", name, err)
+		}
+	}
+}
+
+func certName(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("parse the certificate failed: %v", err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	config := &TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	if _, err := newCertReloader(config, testLogger()); err == nil {
+		t.Fatalf("got no error of the missing certificate")
+	}
+	writeTestCert(t, config.CertFile, config.KeyFile, "first")
+	r, err := newCertReloader(config, testLogger())
+	if err != nil {
+		t.Fatalf("create the cert reloader failed: %v", err)
+	}
+	current, _ := r.get()
+	if name := certName(t, current); name != "first" {
+		t.Errorf("got certificate %s, want first", name)
+	}
+	if current.MinVersion != tls.VersionTLS12 {
+		t.Errorf("got min version %x, want TLS 1.2 by default", current.MinVersion)
+	}
+	if again, _ := r.get(); again != current {
+		t.Errorf("got the config rebuilt without the files changed")
+	}
+
+	// The changed files are loaded again
+	writeTestCert(t, config.CertFile, config.KeyFile, "second")
+	touchFiles(t, time.Now().Add(time.Minute), config.CertFile, config.KeyFile)
+	current, _ = r.get()
+	if name := certName(t, current); name != "second" {
+		t.Errorf("got certificate %s after changed, want second", name)
+	}
+
+	// The broken or removed files keep the current certificate
+	broken := bytes.Repeat([]byte("x"), 16)
+	if err := ioutil.WriteFile(config.CertFile, broken, 0600); err != nil {
+		t.Fatalf("write the broken certificate failed: %v", err)
+	}
+	touchFiles(t, time.Now().Add(2*time.Minute), config.CertFile)
+	if got, err := r.get(); err != nil || got != current {
+		t.Errorf("got error %v or another config of the broken certificate", err)
+	}
+	os.Remove(config.KeyFile)
+	if got, err := r.get(); err != nil || got != current {
+		t.Errorf("got error %v or another config of the removed key", err)
+	}
+}
+
+func TestCertReloaderClientCA(t *testing.T) {
+	dir := t.TempDir()
+	config := &TLSConfig{
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+		MinVersion:   "TLS13",
+	}
+	writeTestCert(t, config.CertFile, config.KeyFile, "server")
+	writeTestCert(t, config.ClientCAFile, filepath.Join(dir, "ca-key.pem"), "ca")
+	r, err := newCertReloader(config, testLogger())
+	if err != nil {
+		t.Fatalf("create the cert reloader failed: %v", err)
+	}
+	current, _ := r.get()
+	if current.ClientAuth != tls.RequireAndVerifyClientCert || current.ClientCAs == nil {
+		t.Errorf("got the client certificates not required")
+	}
+	if current.MinVersion != tls.VersionTLS13 {
+		t.Errorf("got min version %x, want TLS 1.3", current.MinVersion)
+	}
+}